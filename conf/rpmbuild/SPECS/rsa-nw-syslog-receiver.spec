@@ -36,8 +36,21 @@ mv $RPM_BUILD_ROOT/../etc/ $RPM_BUILD_ROOT/
 %attr(0744, root, root) /usr/local/bin/*
 %attr(0644, root, root) /etc/syslogreceiver/*
 %attr(0644, root, root) /usr/lib/systemd/system/rsa-nw-syslog-receiver.service
+%attr(0644, root, root) /usr/lib/systemd/system/rsa-nw-syslog-receiver.socket
+
+%pre
+################################################################################
+# The service runs as an unprivileged system user                              #
+################################################################################
+getent group syslogreceiver >/dev/null || groupadd -r syslogreceiver
+getent passwd syslogreceiver >/dev/null || useradd -r -g syslogreceiver -d / -s /sbin/nologin -c "Syslog Receiver" syslogreceiver
 
 %post
+################################################################################
+# Queues created by earlier versions running as root                           #
+################################################################################
+chown -R syslogreceiver:syslogreceiver /tmp/syslogreceiver* 2>/dev/null || true
+
 ################################################################################
 # Set up a sybobilc link to our new service                                    #
 ################################################################################
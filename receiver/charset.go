@@ -0,0 +1,133 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    charset.go
+//: details: Per listener and source encodings and control characters
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/hwahrmann/rsa-nw-syslog-receiver/syslog"
+)
+
+// Encoding represents the encoding of the messages received on the given
+// listener from the given sources
+type Encoding struct {
+	Listener string
+	Source   []string
+	Encoding string
+}
+
+type encodingRule struct {
+	listener string
+	networks []*net.IPNet
+	charset  *syslog.Charset
+}
+
+// Charsets selects the encoding for a client and listener
+type Charsets struct {
+	charset *syslog.Charset
+	rules   []encodingRule
+}
+
+// NewCharsets constructs the encoding rules from the options
+func NewCharsets(opts *Options) (*Charsets, error) {
+	var err error
+
+	c := &Charsets{}
+	if c.charset, err = syslog.LookupCharset(opts.Encoding); err != nil {
+		return nil, err
+	}
+
+	for i, encoding := range opts.Encodings {
+		rule := encodingRule{listener: encoding.Listener}
+		if rule.charset, err = syslog.LookupCharset(encoding.Encoding); err != nil {
+			return nil, fmt.Errorf("Encoding %d: %s", i+1, err)
+		}
+		if rule.networks, err = parseCIDRs(encoding.Source); err != nil {
+			return nil, fmt.Errorf("Encoding %d: %s", i+1, err)
+		}
+
+		c.rules = append(c.rules, rule)
+	}
+
+	return c, nil
+}
+
+// ForClient returns the charset of the first rule matching the client and
+// listener. The default encoding is returned, when no rule matches
+func (c *Charsets) ForClient(client string, listener string) *syslog.Charset {
+	ip := clientIP(client)
+
+	for _, rule := range c.rules {
+		if rule.listener != "" && !matchListener(rule.listener, listener) {
+			continue
+		}
+		if rule.networks != nil && !containsIP(rule.networks, ip) {
+			continue
+		}
+		return rule.charset
+	}
+
+	return c.charset
+}
+
+// Returns msg with the control characters handled as configured. Escaped
+// are newlines as \n, carriage returns as \r and others as \xNN. Tabs are
+// kept
+func controlChars(msg string) string {
+	if opts.ControlChars == controlCharsKeep {
+		return msg
+	}
+
+	i := strings.IndexFunc(msg, isControl)
+	if i < 0 {
+		return msg
+	}
+
+	var b strings.Builder
+	b.Grow(len(msg) + 8)
+	b.WriteString(msg[:i])
+	for _, r := range msg[i:] {
+		if !isControl(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if opts.ControlChars == controlCharsDrop {
+			continue
+		}
+		switch r {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		}
+	}
+	return b.String()
+}
+
+// C0 controls except tab, DEL and the C1 controls U+0080 to U+009F
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\t') || (r >= 0x7F && r <= 0x9F)
+}
@@ -0,0 +1,207 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    dedup.go
+//: details: Suppression of duplicate and repeated events
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hwahrmann/rsa-nw-syslog-receiver/syslog"
+)
+
+type dedupKey [16]byte
+
+type dedupEntry struct {
+	expires time.Time
+	slot    int
+}
+
+// Dedup detects events seen before within a time window. At most size
+// events are remembered, the oldest are forgotten first
+type Dedup struct {
+	sync.Mutex
+	window time.Duration
+	size   int
+	seen   map[dedupKey]dedupEntry
+	slots  []dedupKey
+	next   int
+}
+
+// NewDedup constructs a Dedup. nil is returned for a window of 0, which
+// disables it
+func NewDedup(window time.Duration, size int) *Dedup {
+	if window <= 0 {
+		return nil
+	}
+
+	return &Dedup{window: window, size: size, seen: map[dedupKey]dedupEntry{}}
+}
+
+// Duplicate returns true, when an event with the same host, time and
+// message has been seen within the window
+func (d *Dedup) Duplicate(message *Message, now time.Time) bool {
+	if d == nil {
+		return false
+	}
+
+	var key dedupKey
+	sum := sha256.Sum256([]byte(message.Host + "\x00" + message.Time + "\x00" + message.Msg))
+	copy(key[:], sum[:])
+
+	d.Lock()
+	defer d.Unlock()
+
+	if entry, ok := d.seen[key]; ok && now.Before(entry.expires) {
+		return true
+	}
+
+	// Reuse the slot of the oldest event, when all are used
+	slot := len(d.slots)
+	if slot < d.size {
+		d.slots = append(d.slots, key)
+	} else {
+		slot = d.next
+		if old, ok := d.seen[d.slots[slot]]; ok && old.slot == slot {
+			delete(d.seen, d.slots[slot])
+		}
+		d.slots[slot] = key
+		d.next = (d.next + 1) % d.size
+	}
+	d.seen[key] = dedupEntry{expires: now.Add(d.window), slot: slot}
+
+	return false
+}
+
+// An event waiting to be forwarded
+type pendingMessage struct {
+	syslogmsg syslog.LogParts
+	message   *Message
+	search    *Search
+	// Set, when the client rate limits took tokens or spool the event
+	tokens bool
+	spool  bool
+}
+
+type repeatState struct {
+	pendingMessage
+	content string
+	count   int
+	// The times of the first suppressed and the last message
+	first time.Time
+	last  time.Time
+}
+
+// Repeats collapses identical consecutive messages of a host into a single
+// "last message repeated N times" message
+type Repeats struct {
+	sync.Mutex
+	flush time.Duration
+	hosts map[string]*repeatState
+}
+
+// NewRepeats constructs a Repeats. The repeat count is forwarded at the
+// latest after flush
+func NewRepeats(flush time.Duration) *Repeats {
+	return &Repeats{flush: flush, hosts: map[string]*repeatState{}}
+}
+
+// Check returns true, when the message repeats the previous message of its
+// host. Messages are compared by tag and MSG part, as the header carries the
+// timestamp. Otherwise the summary of the previous repeats is returned, if any
+func (r *Repeats) Check(syslogmsg syslog.LogParts, message *Message, search *Search, now time.Time) (bool, *pendingMessage) {
+	if r == nil {
+		return false, nil
+	}
+
+	msg, ok := syslogmsg["msg"].(string)
+	if !ok {
+		msg, _ = syslogmsg["content"].(string)
+	}
+	content := message.Tag + "\x00" + msg
+
+	r.Lock()
+	defer r.Unlock()
+
+	state, ok := r.hosts[message.Host]
+	if ok && state.content == content {
+		if state.count == 0 {
+			state.first = now
+		}
+		state.count++
+		state.last = now
+		return true, nil
+	}
+
+	var summary *pendingMessage
+	if ok {
+		summary = state.summary()
+	}
+	// Keep a copy, as the message is rewritten when it is forwarded
+	copied := *message
+	r.hosts[message.Host] = &repeatState{
+		pendingMessage: pendingMessage{syslogmsg: syslogmsg, message: &copied, search: search},
+		content:        content,
+		last:           now,
+	}
+
+	return false, summary
+}
+
+// Expired returns the summaries of repeats, whose first repeat is older than
+// the flush time, so the count is forwarded at least every flush time, even
+// while the message keeps repeating. Hosts without recent messages are
+// forgotten
+func (r *Repeats) Expired(now time.Time) []*pendingMessage {
+	var summaries []*pendingMessage
+
+	r.Lock()
+	defer r.Unlock()
+
+	for host, state := range r.hosts {
+		if state.count > 0 && now.Sub(state.first) >= r.flush {
+			summaries = append(summaries, state.summary())
+			state.count = 0
+		}
+		if state.count == 0 && now.Sub(state.last) >= r.flush {
+			delete(r.hosts, host)
+		}
+	}
+
+	return summaries
+}
+
+// Returns the "last message repeated" message or nil, when there were no
+// repeats
+func (state *repeatState) summary() *pendingMessage {
+	if state.count == 0 {
+		return nil
+	}
+
+	message := *state.message
+	message.Msg = "last message repeated " + strconv.Itoa(state.count) + " times"
+	message.SampleRate = ""
+	message.Time = formatTime(state.last)
+
+	return &pendingMessage{syslogmsg: state.syslogmsg, message: &message, search: state.search}
+}
@@ -0,0 +1,293 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    filter.go
+//: details: Rules to drop or keep events before they are queued
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hwahrmann/rsa-nw-syslog-receiver/syslog"
+)
+
+// Condition represents the conditions of a filter or route rule. All given
+// conditions have to match
+type Condition struct {
+	Severity []string
+	Facility []string
+	Source   []string
+	Listener string
+	Search   string
+	Host     string
+	Tag      string
+	Message  string
+}
+
+// Filter represents a rule to drop or keep events
+type Filter struct {
+	Name      string
+	Action    string
+	Condition `yaml:",inline"`
+}
+
+// FilterStats represents the hits of a filter rule
+type FilterStats struct {
+	Name   string
+	Action string
+	Hits   uint64
+}
+
+// The actions of a filter rule
+const (
+	filterActionDrop = "drop"
+	filterActionKeep = "keep"
+)
+
+var severityNames = map[string]int{
+	"emerg": 0, "emergency": 0, "alert": 1, "crit": 2, "critical": 2,
+	"err": 3, "error": 3, "warning": 4, "warn": 4, "notice": 5,
+	"info": 6, "informational": 6, "debug": 7,
+}
+
+var facilityNames = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+type condition struct {
+	severities map[int]bool
+	facilities map[int]bool
+	networks   []*net.IPNet
+	listener   string
+	search     string
+	host       *regexp.Regexp
+	tag        *regexp.Regexp
+	message    *regexp.Regexp
+}
+
+type filterRule struct {
+	*condition
+	name   string
+	action string
+	hits   uint64
+}
+
+// Filters evaluates the filter rules in order. The first matching rule
+// decides, events matching no rule are kept
+type Filters struct {
+	rules []*filterRule
+}
+
+// NewFilters constructs the filter rules from the options
+func NewFilters(filters []Filter) (*Filters, error) {
+	var err error
+
+	f := &Filters{}
+	for i, filter := range filters {
+		rule := &filterRule{name: filter.Name, action: filter.Action}
+		if rule.name == "" {
+			rule.name = "filter" + strconv.Itoa(i+1)
+		}
+		if rule.action != filterActionDrop && rule.action != filterActionKeep {
+			return nil, fmt.Errorf("Filter %s: unknown action %q", rule.name, filter.Action)
+		}
+		if rule.condition, err = newCondition(filter.Condition); err != nil {
+			return nil, fmt.Errorf("Filter %s: %s", rule.name, err)
+		}
+
+		f.rules = append(f.rules, rule)
+	}
+
+	return f, nil
+}
+
+// Compile the conditions of a rule
+func newCondition(c Condition) (*condition, error) {
+	var err error
+
+	cond := &condition{listener: c.Listener, search: c.Search}
+	if cond.severities, err = parseLevels(c.Severity, severityNames, 7); err != nil {
+		return nil, err
+	}
+	if cond.facilities, err = parseLevels(c.Facility, facilityNames, 23); err != nil {
+		return nil, err
+	}
+	if cond.networks, err = parseCIDRs(c.Source); err != nil {
+		return nil, err
+	}
+	if cond.host, err = compileOptional(c.Host); err != nil {
+		return nil, err
+	}
+	if cond.tag, err = compileOptional(c.Tag); err != nil {
+		return nil, err
+	}
+	if cond.message, err = compileOptional(c.Message); err != nil {
+		return nil, err
+	}
+
+	return cond, nil
+}
+
+// Keep returns false, when the event is to be dropped
+func (f *Filters) Keep(syslogmsg syslog.LogParts, message *Message, search *Search) bool {
+	rule := f.match(syslogmsg, message, search)
+	if rule == nil {
+		return true
+	}
+
+	atomic.AddUint64(&rule.hits, 1)
+	return rule.action == filterActionKeep
+}
+
+// Returns the first matching rule or nil
+func (f *Filters) match(syslogmsg syslog.LogParts, message *Message, search *Search) *filterRule {
+	for _, rule := range f.rules {
+		if rule.matches(syslogmsg, message, search) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the hits of the filter rules
+func (f *Filters) Stats() []FilterStats {
+	stats := []FilterStats{}
+	for _, rule := range f.rules {
+		stats = append(stats, FilterStats{Name: rule.name, Action: rule.action, Hits: atomic.LoadUint64(&rule.hits)})
+	}
+
+	return stats
+}
+
+// Returns true, when all conditions match. search is the Search, which
+// extracted the message, or nil
+func (cond *condition) matches(syslogmsg syslog.LogParts, message *Message, search *Search) bool {
+	// The PRI of the message includes the default for messages without
+	priority, _ := strconv.Atoi(messagePriority(message))
+	if cond.severities != nil && !cond.severities[priority%8] {
+		return false
+	}
+
+	if cond.facilities != nil && !cond.facilities[priority/8] {
+		return false
+	}
+
+	if cond.networks != nil {
+		ip := clientIP(message.Client)
+		found := false
+		for _, network := range cond.networks {
+			if ip != nil && network.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if cond.listener != "" {
+		listener, _ := syslogmsg["listener"].(string)
+		if !matchListener(cond.listener, listener) {
+			return false
+		}
+	}
+
+	if cond.search != "" && (search == nil || search.Name != cond.search) {
+		return false
+	}
+
+	if cond.host != nil && !cond.host.MatchString(message.Host) {
+		return false
+	}
+	if cond.tag != nil && !cond.tag.MatchString(message.Tag) {
+		return false
+	}
+	if cond.message != nil && !cond.message.MatchString(message.Msg) {
+		return false
+	}
+
+	return true
+}
+
+// A listener is given as port or as address and port. The wildcard
+// address matches all local addresses
+func matchListener(want string, listener string) bool {
+	if want == listener {
+		return true
+	}
+
+	host, port, err := net.SplitHostPort(listener)
+	if err != nil {
+		return false
+	}
+	if want == port {
+		return true
+	}
+
+	wantHost, wantPort, err := net.SplitHostPort(want)
+	if err != nil || wantPort != port {
+		return false
+	}
+	wantIP := net.ParseIP(wantHost)
+	return wantHost == "" || (wantIP != nil && wantIP.Equal(net.ParseIP(host)))
+}
+
+// Parse severities or facilities given by name or number. nil is returned
+// for an empty list, which matches everything
+func parseLevels(levels []string, names map[string]int, max int) (map[int]bool, error) {
+	if len(levels) == 0 {
+		return nil, nil
+	}
+
+	result := map[int]bool{}
+	for _, level := range levels {
+		if value, ok := names[strings.ToLower(level)]; ok {
+			result[value] = true
+			continue
+		}
+
+		value, err := strconv.Atoi(level)
+		if err != nil || value < 0 || value > max {
+			return nil, fmt.Errorf("unknown level %q", level)
+		}
+		result[value] = true
+	}
+
+	return result, nil
+}
+
+// Compile a regex, which may be empty
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile(expr)
+}
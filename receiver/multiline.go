@@ -0,0 +1,126 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    multiline.go
+//: details: Per listener and source multiline rules
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hwahrmann/rsa-nw-syslog-receiver/syslog"
+)
+
+// Multiline represents a rule to stitch lines into one message for the
+// given listener and sources
+type Multiline struct {
+	Listener     string
+	Source       []string
+	Start        string
+	Continuation string
+	MaxLines     int           `yaml:"maxlines"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// Defaults of a multiline rule
+const (
+	multilineMaxLines = 500
+	multilineTimeout  = time.Second
+)
+
+type multilineRule struct {
+	listener string
+	networks []*net.IPNet
+	rule     *syslog.Multiline
+}
+
+// Multilines selects the multiline rule for a client and listener
+type Multilines struct {
+	rules []multilineRule
+}
+
+// NewMultilines constructs the multiline rules from the options
+func NewMultilines(multilines []Multiline) (*Multilines, error) {
+	var err error
+
+	m := &Multilines{}
+	for i, multiline := range multilines {
+		if multiline.Start == "" && multiline.Continuation == "" {
+			return nil, fmt.Errorf("Multiline %d: requires a start or continuation regex", i+1)
+		}
+
+		rule := multilineRule{listener: multiline.Listener, rule: &syslog.Multiline{
+			MaxLines: multiline.MaxLines,
+			Timeout:  multiline.Timeout,
+		}}
+		if rule.rule.MaxLines <= 0 {
+			rule.rule.MaxLines = multilineMaxLines
+		}
+		if rule.rule.Timeout <= 0 {
+			rule.rule.Timeout = multilineTimeout
+		}
+
+		if rule.networks, err = parseCIDRs(multiline.Source); err != nil {
+			return nil, fmt.Errorf("Multiline %d: %s", i+1, err)
+		}
+		if rule.rule.Start, err = compileOptional(multiline.Start); err != nil {
+			return nil, fmt.Errorf("Multiline %d: %s", i+1, err)
+		}
+		if rule.rule.Continuation, err = compileOptional(multiline.Continuation); err != nil {
+			return nil, fmt.Errorf("Multiline %d: %s", i+1, err)
+		}
+
+		m.rules = append(m.rules, rule)
+	}
+
+	return m, nil
+}
+
+// ForClient returns the first rule matching the client and listener or nil
+func (m *Multilines) ForClient(client string, listener string) *syslog.Multiline {
+	ip := clientIP(client)
+
+	for _, rule := range m.rules {
+		if rule.listener != "" && !matchListener(rule.listener, listener) {
+			continue
+		}
+		if rule.networks != nil && !containsIP(rule.networks, ip) {
+			continue
+		}
+		return rule.rule
+	}
+
+	return nil
+}
+
+// Returns true, when one of the networks contains the IP
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
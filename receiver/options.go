@@ -26,9 +26,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"regexp"
 	"runtime"
+	"time"
 
 	"github.com/google/logger"
 	"gopkg.in/yaml.v2"
@@ -48,23 +48,117 @@ type Options struct {
 	PIDFile            string `yaml:"pid-file"`
 	Logger             *logger.Logger
 	version            bool
-	StatsEnabled       bool     `yaml:"statsenabled"`
-	StatsHTTPPort      int      `yaml:"statsport"`
-	LogDecoder         string   `yaml:"logdecoder"`
-	LogDecoderProtocol string   `yaml:"logdecoderprotocol"`
-	ListenPort         int      `yaml:"listenport"`
-	Protocol           string   `yaml:"listenprotocol"`
-	Workers            int      `yaml:"workers"`
-	Search             []Search `yaml:"search"`
+	test               bool
+	testClient         string
+	pidFile            *os.File
+	StatsEnabled       bool              `yaml:"statsenabled"`
+	StatsHTTPPort      int               `yaml:"statsport"`
+	StatsAddress       string            `yaml:"statsaddress"`
+	LogDecoder         string            `yaml:"logdecoder"`
+	LogDecoderProtocol string            `yaml:"logdecoderprotocol"`
+	LogDecoderFraming  string            `yaml:"logdecoderframing"`
+	LogDecoderDelim    string            `yaml:"logdecoderdelimiter"`
+	ListenAddresses    []string          `yaml:"listenaddresses"`
+	ListenPort         int               `yaml:"listenport"`
+	Protocol           string            `yaml:"listenprotocol"`
+	Workers            int               `yaml:"workers"`
+	Search             []Search          `yaml:"search"`
+	Timezone           string            `yaml:"timezone"`
+	Timezones          []Timezone        `yaml:"timezones"`
+	MaxClockSkew       time.Duration     `yaml:"maxclockskew"`
+	TimeUnit           string            `yaml:"timeunit"`
+	ListenFormat       string            `yaml:"listenformat"`
+	ProxyProtocol      bool              `yaml:"proxyprotocol"`
+	TrustedProxies     []string          `yaml:"trustedproxies"`
+	ProxyListeners     []string          `yaml:"proxylisteners"`
+	GELFPort           int               `yaml:"gelfport"`
+	GELFProtocol       string            `yaml:"gelfprotocol"`
+	EmitPriority       bool              `yaml:"emitpriority"`
+	HostFields         []string          `yaml:"hostfields"`
+	DeviceTypes        map[string]string `yaml:"devicetypes"`
+	RelayMap           string            `yaml:"relaymap"`
+	ResolveHosts       bool              `yaml:"resolvehosts"`
+	ResolveAddresses   bool              `yaml:"resolveaddresses"`
+	ResolveTTL         time.Duration     `yaml:"resolvettl"`
+	ResolveNegativeTTL time.Duration     `yaml:"resolvenegativettl"`
+	ResolveCacheSize   int               `yaml:"resolvecachesize"`
+	HostsFile          string            `yaml:"hostsfile"`
+	Filters            []Filter          `yaml:"filters"`
+	Destinations       []Destination     `yaml:"destinations"`
+	Routes             []Route           `yaml:"routes"`
+	Rewrites           []Rewrite         `yaml:"rewrites"`
+	RateLimits         []RateLimit       `yaml:"ratelimits"`
+	Sampling           []Sample          `yaml:"sampling"`
+	Multiline          []Multiline       `yaml:"multiline"`
+	Encoding           string            `yaml:"encoding"`
+	Encodings          []Encoding        `yaml:"encodings"`
+	ControlChars       string            `yaml:"controlchars"`
+	DedupWindow        time.Duration     `yaml:"dedupwindow"`
+	DedupSize          int               `yaml:"dedupsize"`
+	CollapseRepeats    bool              `yaml:"collapserepeats"`
+	RepeatFlush        time.Duration     `yaml:"repeatflush"`
 }
 
 // Search represents a Search structure
 type Search struct {
-	Regex   string
-	Type    string
-	Mapping []string
+	Name        string
+	Regex       string
+	Type        string
+	Mapping     []string
+	EventTime   string `yaml:"eventtime"`
+	TimeLayout  string `yaml:"timelayout"`
+	ReceiveTime bool   `yaml:"receivetime"`
+	HostPath    string `yaml:"hostpath"`
+	TimePath    string `yaml:"timepath"`
+	MessagePath string `yaml:"messagepath"`
+	// The default RFC3164 and RFC5424 searches
+	builtin bool
 }
 
+// Searches of this type match JSON events and extract by JSON paths
+const searchTypeJSON = "json"
+
+// The formats of the received messages
+const (
+	listenFormatSyslog = "syslog"
+	listenFormatJSON   = "json"
+)
+
+// The handling of control characters in the forwarded messages
+const (
+	controlCharsEscape = "escape"
+	controlCharsDrop   = "drop"
+	controlCharsKeep   = "keep"
+)
+
+// The framing of the events sent by TCP
+const (
+	// Events are terminated by a delimiter
+	framingNonTransparent = "non-transparent"
+	// Events are prefixed by their length as in RFC 6587
+	framingOctetCounting = "octet-counting"
+)
+
+// The delimiters of the non-transparent framing
+var delimiters = map[string]string{
+	"lf":   "\n",
+	"crlf": "\r\n",
+	"nul":  "\x00",
+}
+
+// The sources of the event time in the forwarded header
+const (
+	eventTimeDevice  = "device"
+	eventTimeReceive = "receive"
+	eventTimeCustom  = "custom"
+)
+
+// The units of the Unix time in the forwarded header
+const (
+	timeUnitSeconds      = "seconds"
+	timeUnitMilliseconds = "milliseconds"
+)
+
 func init() {
 	if version == "" {
 		version = "1.0"
@@ -75,15 +169,30 @@ func init() {
 func NewOptions() *Options {
 	options := Options{}
 	options.Verbose = false
-	options.PIDFile = "/var/run/rsa-nw-syslog-receiver.pid"
+	options.PIDFile = "/run/rsa-nw-syslog-receiver/rsa-nw-syslog-receiver.pid"
+	options.testClient = "127.0.0.1"
 	options.ListenPort = 5514
 	options.LogDecoder = "127.0.0.1"
 	options.LogDecoderProtocol = "tcp"
+	options.LogDecoderFraming = framingNonTransparent
+	options.LogDecoderDelim = "lf"
 	options.Protocol = "tcp"
+	options.ListenFormat = listenFormatSyslog
+	options.GELFProtocol = "udp"
 	options.Workers = 5
 	options.Logger = logger.Init("", options.Verbose, true, ioutil.Discard)
 	options.StatsEnabled = true
 	options.StatsHTTPPort = 8081
+	options.Timezone = "UTC"
+	options.TimeUnit = timeUnitSeconds
+	options.HostFields = []string{"dvchost", "dvc"}
+	options.ResolveTTL = time.Hour
+	options.ResolveNegativeTTL = 5 * time.Minute
+	options.ResolveCacheSize = 10000
+	options.DedupSize = 100000
+	options.RepeatFlush = 30 * time.Second
+	options.Encoding = "utf-8"
+	options.ControlChars = controlCharsEscape
 	logger.SetFlags(0)
 	return &options
 }
@@ -101,46 +210,131 @@ func GetOptions() *Options {
 		if err != nil {
 			opts.Logger.Fatalf("Error in Regex Pattern: %s", err)
 		}
+
+		switch search.EventTime {
+		case "", eventTimeDevice, eventTimeReceive:
+		case eventTimeCustom:
+			if search.TimeLayout == "" {
+				opts.Logger.Fatalf("Search %q: eventtime custom requires a timelayout", search.Regex)
+			}
+		default:
+			opts.Logger.Fatalf("Search %q: unknown eventtime %q", search.Regex, search.EventTime)
+		}
 	}
 
-	if ok := opts.receiverIsRunning(); ok {
-		opts.Logger.Fatal("The Syslog Receiver is already running!")
+	if opts.ListenFormat != listenFormatSyslog && opts.ListenFormat != listenFormatJSON {
+		opts.Logger.Fatalf("Unknown listenformat %q", opts.ListenFormat)
 	}
 
-	opts.pidWrite()
+	if opts.TimeUnit != timeUnitSeconds && opts.TimeUnit != timeUnitMilliseconds {
+		opts.Logger.Fatalf("Unknown timeunit %q", opts.TimeUnit)
+	}
 
-	opts.Logger.Infof("Welcome to Syslog Receiver v.%s GPL v3", version)
-	opts.Logger.Info("Copyright (C) 2019 Helmut Wahrmann.")
+	if _, err = parseCIDRs(opts.TrustedProxies); err != nil {
+		opts.Logger.Fatalf("Error in trustedproxies: %s", err)
+	}
+	if opts.ProxyProtocol && len(opts.TrustedProxies) == 0 {
+		opts.Logger.Fatal("proxyprotocol requires trustedproxies")
+	}
 
-	return opts
-}
+	if opts.RelayMap != "" {
+		if _, err = NewRelayMap(opts.RelayMap); err != nil {
+			opts.Logger.Fatalf("Error in relaymap: %s", err)
+		}
+	}
 
-func (opts Options) pidWrite() {
-	f, err := os.OpenFile(opts.PIDFile, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		opts.Logger.Info(err)
-		return
+	if _, err = NewFilters(opts.Filters); err != nil {
+		opts.Logger.Fatalf("Error in filters: %s", err)
 	}
 
-	_, err = fmt.Fprintf(f, "%d", os.Getpid())
-	if err != nil {
-		opts.Logger.Info(err)
+	if _, err = NewRoutes(opts); err != nil {
+		opts.Logger.Fatalf("Error in routes: %s", err)
 	}
-}
 
-func (opts Options) receiverIsRunning() bool {
-	b, err := ioutil.ReadFile(opts.PIDFile)
-	if err != nil {
-		return false
+	if _, err = NewRewrites(opts.Rewrites); err != nil {
+		opts.Logger.Fatalf("Error in rewrites: %s", err)
 	}
 
-	cmd := exec.Command("kill", "-0", string(b))
-	_, err = cmd.Output()
-	if err != nil {
-		return false
+	if _, err = NewRateLimits(opts.RateLimits); err != nil {
+		opts.Logger.Fatalf("Error in ratelimits: %s", err)
+	}
+
+	if _, err = NewCharsets(opts); err != nil {
+		opts.Logger.Fatalf("Error in encodings: %s", err)
+	}
+
+	switch opts.ControlChars {
+	case controlCharsEscape, controlCharsDrop, controlCharsKeep:
+	default:
+		opts.Logger.Fatalf("Unknown controlchars %q", opts.ControlChars)
+	}
+
+	if _, err = NewMultilines(opts.Multiline); err != nil {
+		opts.Logger.Fatalf("Error in multiline: %s", err)
+	}
+
+	if _, err = NewSampling(opts.Sampling); err != nil {
+		opts.Logger.Fatalf("Error in sampling: %s", err)
+	}
+
+	if opts.DedupWindow > 0 && opts.DedupSize <= 0 {
+		opts.Logger.Fatalf("dedupsize must be greater than 0")
+	}
+
+	if opts.CollapseRepeats && opts.RepeatFlush <= 0 {
+		opts.Logger.Fatalf("repeatflush must be greater than 0")
+	}
+
+	for _, filter := range opts.Filters {
+		if !opts.hasSearch(filter.Search) {
+			opts.Logger.Fatalf("Filter %s: unknown search %q", filter.Name, filter.Search)
+		}
+	}
+	for _, route := range opts.Routes {
+		if !opts.hasSearch(route.Search) {
+			opts.Logger.Fatalf("Route to %s: unknown search %q", route.Destination, route.Search)
+		}
+	}
+
+	if opts.ResolveCacheSize <= 0 {
+		opts.Logger.Fatalf("resolvecachesize must be greater than 0")
+	}
+
+	if _, err = NewResolver(opts); err != nil {
+		opts.Logger.Fatalf("Error in hostsfile: %s", err)
 	}
 
-	return true
+	if _, err = NewTimezones(opts); err != nil {
+		opts.Logger.Fatalf("Error in Timezone: %s", err)
+	}
+
+	// The rule test mode runs besides a running instance
+	if opts.test {
+		return opts
+	}
+
+	if err = opts.pidLock(); err != nil {
+		opts.Logger.Fatal(err)
+	}
+
+	opts.Logger.Infof("Welcome to Syslog Receiver v.%s GPL v3", version)
+	opts.Logger.Info("Copyright (C) 2019 Helmut Wahrmann.")
+
+	return opts
+}
+
+// Returns true, when a search with the name exists or the name is empty
+func (opts *Options) hasSearch(name string) bool {
+	if name == "" {
+		return true
+	}
+
+	for _, search := range opts.Search {
+		if search.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (opts Options) syslogreceiverVersion() {
@@ -160,6 +354,9 @@ func (opts *Options) syslogreceiverFlagSet() {
 	// global options
 	flag.BoolVar(&opts.Verbose, "verbose", opts.Verbose, "enable/disable verbose logging")
 	flag.BoolVar(&opts.version, "version", opts.version, "show version")
+	flag.StringVar(&opts.PIDFile, "pid-file", opts.PIDFile, "pid file, must be unique per instance")
+	flag.BoolVar(&opts.test, "test", opts.test, "test the rules with messages read from stdin")
+	flag.StringVar(&opts.testClient, "test-client", opts.testClient, "client address of the test messages")
 
 	flag.Usage = func() {
 		flag.PrintDefaults()
@@ -199,8 +396,8 @@ func syslogreceiverLoadCfg(opts *Options) {
 	}
 
 	// Adding the default regexes to the end
-	s := Search{Regex: regexRFC3164, Type: "syslog"}
+	s := Search{Regex: regexRFC3164, Type: "syslog", builtin: true}
 	opts.Search = append(opts.Search, s)
-	s = Search{Regex: regexRFC5424, Type: "syslog"}
+	s = Search{Regex: regexRFC5424, Type: "syslog", builtin: true}
 	opts.Search = append(opts.Search, s)
 }
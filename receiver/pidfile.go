@@ -0,0 +1,124 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    pidfile.go
+//: details: PID file handling with flock based single instance locking
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Open and lock the PID file and write our PID to it. The lock is held until
+// the process exits, so a crashed instance never blocks a restart.
+func (opts *Options) pidLock() error {
+	// An empty pid-file disables it, e.g. when running under systemd
+	if opts.PIDFile == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.PIDFile), 0755); err != nil {
+		return fmt.Errorf("Error creating pid file directory: %s", err)
+	}
+
+	f, err := opts.pidOpenLocked()
+	if err != nil {
+		return err
+	}
+
+	// The lock was free, so any PID left in the file is stale
+	b, err := ioutil.ReadAll(f)
+	if err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && pid != os.Getpid() {
+			if syscall.Kill(pid, 0) == nil {
+				opts.Logger.Warningf("PID %d from %s is alive, but does not hold the lock. Assuming PID reuse", pid, opts.PIDFile)
+			} else {
+				opts.Logger.Infof("Removing stale PID %d from %s", pid, opts.PIDFile)
+			}
+		}
+	}
+
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		opts.Logger.Info(err)
+	}
+
+	opts.pidFile = f
+	return nil
+}
+
+// Open and lock the PID file. An exiting instance removes the file before
+// releasing the lock, so the locked file may no longer be the one at the
+// path. In that case it is opened again
+func (opts *Options) pidOpenLocked() (*os.File, error) {
+	for {
+		f, err := os.OpenFile(opts.PIDFile, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening pid file %s: %s", opts.PIDFile, err)
+		}
+
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != nil {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, errors.New("The Syslog Receiver is already running!")
+			}
+			return nil, fmt.Errorf("Error locking pid file %s: %s", opts.PIDFile, err)
+		}
+
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Error checking pid file %s: %s", opts.PIDFile, err)
+		}
+		current, err := os.Stat(opts.PIDFile)
+		if err == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Error checking pid file %s: %s", opts.PIDFile, err)
+		}
+	}
+}
+
+// Remove the PID file and release the lock
+func (opts *Options) pidRemove() {
+	if opts.pidFile == nil {
+		return
+	}
+
+	// Remove before unlocking, so a starting instance never sees our PID.
+	// An instance, which has opened the removed file, detects it after
+	// locking
+	if err := os.Remove(opts.PIDFile); err != nil {
+		opts.Logger.Info(err)
+	}
+	opts.pidFile.Close()
+	opts.pidFile = nil
+}
@@ -0,0 +1,304 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    ratelimit.go
+//: details: Token bucket rate limits per client, host or global
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit represents a token bucket rate limit
+type RateLimit struct {
+	Scope      string
+	Rate       float64
+	Burst      float64
+	Action     string
+	SampleRate uint64 `yaml:"samplerate"`
+}
+
+// RateLimitStats represents the throttled events of a rate limit
+type RateLimitStats struct {
+	Scope     string
+	Action    string
+	Throttled uint64
+	Dropped   uint64
+	Spooled   uint64
+}
+
+// The scopes of a rate limit
+const (
+	rateLimitClient = "client"
+	rateLimitHost   = "host"
+	rateLimitGlobal = "global"
+)
+
+// The actions for events exceeding a rate limit
+const (
+	rateLimitDrop   = "drop"
+	rateLimitSample = "sample"
+	rateLimitSpool  = "spool"
+)
+
+// The log parts set by the client rate limits
+const (
+	logPartsRateTokens = "ratelimit_tokens"
+	logPartsRateSpool  = "ratelimit_spool"
+)
+
+const (
+	// Max. number of buckets per rate limit
+	rateLimitMaxBuckets = 100000
+	// Buckets not used for this time are removed, when the max. is reached
+	rateLimitIdle = time.Minute
+)
+
+type tokenBucket struct {
+	tokens    float64
+	last      time.Time
+	throttled uint64
+}
+
+type rateLimiter struct {
+	sync.Mutex
+	RateLimit
+	buckets   map[string]*tokenBucket
+	throttled uint64
+	dropped   uint64
+	spooled   uint64
+}
+
+// RateLimits applies the rate limits in order. Client limits are checked,
+// when an event is received, the others after filtering
+type RateLimits struct {
+	limiters []*rateLimiter
+	clients  []*rateLimiter
+	others   []*rateLimiter
+}
+
+// NewRateLimits constructs the rate limits from the options
+func NewRateLimits(limits []RateLimit) (*RateLimits, error) {
+	r := &RateLimits{}
+	for i, limit := range limits {
+		switch limit.Scope {
+		case rateLimitClient, rateLimitHost, rateLimitGlobal:
+		default:
+			return nil, fmt.Errorf("Rate limit %d: unknown scope %q", i+1, limit.Scope)
+		}
+
+		switch limit.Action {
+		case "":
+			limit.Action = rateLimitDrop
+		case rateLimitDrop, rateLimitSpool:
+		case rateLimitSample:
+			if limit.SampleRate == 0 {
+				return nil, fmt.Errorf("Rate limit %d: sample requires a samplerate", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("Rate limit %d: unknown action %q", i+1, limit.Action)
+		}
+
+		if limit.Rate <= 0 {
+			return nil, fmt.Errorf("Rate limit %d: rate must be greater than 0", i+1)
+		}
+		if limit.Burst < limit.Rate {
+			limit.Burst = limit.Rate
+		}
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+
+		limiter := &rateLimiter{RateLimit: limit, buckets: map[string]*tokenBucket{}}
+		r.limiters = append(r.limiters, limiter)
+		if limit.Scope == rateLimitClient {
+			r.clients = append(r.clients, limiter)
+		} else {
+			r.others = append(r.others, limiter)
+		}
+	}
+
+	return r, nil
+}
+
+// AllowClient applies the client rate limits to an event received from
+// client. Returns true, when the event is within the limits or kept by
+// sampling. Otherwise the action of the first exceeded limit is returned.
+// taken is true, when tokens have been taken, which are to be given back by
+// Refund, when the event is throttled later
+func (r *RateLimits) AllowClient(client string, now time.Time) (action string, ok bool, taken bool) {
+	key := clientKey(client)
+	return allow(r.clients, func(*rateLimiter) string { return key }, now)
+}
+
+// Allow applies the host and global rate limits. Returns true, when the
+// event is within the limits or kept by sampling. Otherwise the action of
+// the first exceeded limit is returned
+func (r *RateLimits) Allow(message *Message, now time.Time) (string, bool) {
+	action, ok, _ := allow(r.others, func(limiter *rateLimiter) string {
+		if limiter.Scope == rateLimitHost {
+			return message.Host
+		}
+		return ""
+	}, now)
+	return action, ok
+}
+
+// Refund gives back the tokens taken by AllowClient
+func (r *RateLimits) Refund(client string) {
+	key := clientKey(client)
+	for _, limiter := range r.clients {
+		limiter.Lock()
+		if bucket, ok := limiter.buckets[key]; ok && bucket.tokens+1 <= limiter.Burst {
+			bucket.tokens++
+		}
+		limiter.Unlock()
+	}
+}
+
+// Spooling returns true, when a rate limit spools throttled events
+func (r *RateLimits) Spooling() bool {
+	for _, limiter := range r.limiters {
+		if limiter.Action == rateLimitSpool {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stats returns the throttled events of the rate limits
+func (r *RateLimits) Stats() []RateLimitStats {
+	stats := []RateLimitStats{}
+	for _, limiter := range r.limiters {
+		stats = append(stats, RateLimitStats{
+			Scope:     limiter.Scope,
+			Action:    limiter.Action,
+			Throttled: atomic.LoadUint64(&limiter.throttled),
+			Dropped:   atomic.LoadUint64(&limiter.dropped),
+			Spooled:   atomic.LoadUint64(&limiter.spooled),
+		})
+	}
+
+	return stats
+}
+
+// Returns the bucket key of a client, its address without port
+func clientKey(client string) string {
+	if ip := clientIP(client); ip != nil {
+		return ip.String()
+	}
+	return client
+}
+
+// Take a token from the bucket of each limiter. Tokens are only taken, when
+// all buckets have one. Otherwise the first exceeded limiter decides
+func allow(limiters []*rateLimiter, key func(*rateLimiter) string, now time.Time) (string, bool, bool) {
+	if len(limiters) == 0 {
+		return "", true, false
+	}
+
+	// The limiters are always locked in the same order
+	for _, limiter := range limiters {
+		limiter.Lock()
+		defer limiter.Unlock()
+	}
+
+	var (
+		exceeded *rateLimiter
+		throttle *tokenBucket
+	)
+	buckets := make([]*tokenBucket, len(limiters))
+	for i, limiter := range limiters {
+		buckets[i] = limiter.bucket(key(limiter), now)
+		if exceeded == nil && buckets[i].tokens < 1 {
+			exceeded, throttle = limiter, buckets[i]
+		}
+	}
+
+	if exceeded == nil {
+		for _, bucket := range buckets {
+			bucket.tokens--
+		}
+		return "", true, true
+	}
+
+	action, ok := exceeded.throttle(throttle)
+	return action, ok, false
+}
+
+// Returns the refilled bucket of key. Must be called with the lock held
+func (l *rateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= rateLimitMaxBuckets {
+			l.sweep(now)
+		}
+		bucket = &tokenBucket{tokens: l.Burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.Rate
+	if bucket.tokens > l.Burst {
+		bucket.tokens = l.Burst
+	}
+	bucket.last = now
+
+	return bucket
+}
+
+// Count an event exceeding the limit. Returns true and the action, when
+// the event is kept by sampling. Must be called with the lock held
+func (l *rateLimiter) throttle(bucket *tokenBucket) (string, bool) {
+	atomic.AddUint64(&l.throttled, 1)
+	bucket.throttled++
+	switch l.Action {
+	case rateLimitSample:
+		if (bucket.throttled-1)%l.SampleRate == 0 {
+			return l.Action, true
+		}
+		atomic.AddUint64(&l.dropped, 1)
+	case rateLimitSpool:
+		atomic.AddUint64(&l.spooled, 1)
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+
+	return l.Action, false
+}
+
+// Remove idle buckets, then arbitrary ones, until there is room for a new
+// one. Must be called with the lock held
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) > rateLimitIdle {
+			delete(l.buckets, key)
+		}
+	}
+
+	for key := range l.buckets {
+		if len(l.buckets) < rateLimitMaxBuckets {
+			break
+		}
+		delete(l.buckets, key)
+	}
+}
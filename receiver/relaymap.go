@@ -0,0 +1,196 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    relaymap.go
+//: details: Mapping of hosts behind relays to their device address
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RelayMapping maps a host sent via a relay to the device IP or name
+// forwarded to the Log Decoder
+type RelayMapping struct {
+	Relay  string
+	Host   string
+	Tag    string
+	Device string
+}
+
+type relayRule struct {
+	network *net.IPNet
+	host    *regexp.Regexp
+	tag     *regexp.Regexp
+	device  string
+}
+
+// RelayMap holds the mappings loaded from the relay map file
+type RelayMap struct {
+	sync.RWMutex
+	file  string
+	rules []relayRule
+}
+
+// NewRelayMap loads the relay map from a CSV or YAML file
+func NewRelayMap(file string) (*RelayMap, error) {
+	r := &RelayMap{file: file}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the relay map file again. On error the current mappings
+// are kept
+func (r *RelayMap) Reload() error {
+	mappings, err := loadRelayMappings(r.file)
+	if err != nil {
+		return err
+	}
+
+	var rules []relayRule
+	for i, m := range mappings {
+		if m.Device == "" {
+			return fmt.Errorf("%s: mapping %d has no device", r.file, i+1)
+		}
+
+		rule := relayRule{device: m.Device}
+		if m.Relay != "" {
+			if rule.network, err = parseCIDR(m.Relay); err != nil {
+				return fmt.Errorf("%s: mapping %d: %s", r.file, i+1, err)
+			}
+		}
+		if m.Host != "" {
+			if rule.host, err = regexp.Compile(m.Host); err != nil {
+				return fmt.Errorf("%s: mapping %d: %s", r.file, i+1, err)
+			}
+		}
+		if m.Tag != "" {
+			if rule.tag, err = regexp.Compile(m.Tag); err != nil {
+				return fmt.Errorf("%s: mapping %d: %s", r.file, i+1, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	r.Lock()
+	r.rules = rules
+	r.Unlock()
+
+	return nil
+}
+
+// Lookup returns the device of the first mapping matching the relay client,
+// host and tag. Empty conditions of a mapping match everything
+func (r *RelayMap) Lookup(client string, host string, tag string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+
+	ip := clientIP(client)
+
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, rule := range r.rules {
+		if rule.network != nil && (ip == nil || !rule.network.Contains(ip)) {
+			continue
+		}
+		if rule.host != nil && !rule.host.MatchString(host) {
+			continue
+		}
+		if rule.tag != nil && !rule.tag.MatchString(tag) {
+			continue
+		}
+		return rule.device, true
+	}
+
+	return "", false
+}
+
+// Size returns the number of mappings
+func (r *RelayMap) Size() int {
+	if r == nil {
+		return 0
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+	return len(r.rules)
+}
+
+// Load the mappings from a YAML file or, for files ending in .csv, from
+// lines of relay,host,tag,device
+func loadRelayMappings(file string) ([]RelayMapping, error) {
+	if strings.ToLower(filepath.Ext(file)) != ".csv" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var mappings []RelayMapping
+		if err = yaml.Unmarshal(b, &mappings); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		return mappings, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	var mappings []RelayMapping
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+
+		// Skip an optional header line
+		if len(mappings) == 0 && strings.EqualFold(record[0], "relay") {
+			continue
+		}
+		mappings = append(mappings, RelayMapping{Relay: record[0], Host: record[1], Tag: record[2], Device: record[3]})
+	}
+
+	return mappings, nil
+}
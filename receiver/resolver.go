@@ -0,0 +1,244 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    resolver.go
+//: details: Resolve extracted hosts with a DNS cache and a hosts file
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	resolveTimeout = 2 * time.Second
+	// Max. number of DNS lookups running at a time
+	resolveMaxLookups = 16
+)
+
+type resolverEntry struct {
+	value   string
+	expires time.Time
+}
+
+// Resolver turns host names into IP addresses and, optionally, IP addresses
+// into host names. Results are cached, failed lookups as well. DNS is
+// queried in the background, so the workers never wait for it
+type Resolver struct {
+	sync.Mutex
+	hostsFile   string
+	names       map[string]string
+	addresses   map[string]string
+	cache       map[string]resolverEntry
+	ttl         time.Duration
+	negativeTTL time.Duration
+	cacheSize   int
+	hosts       bool
+	addrs       bool
+	// The hosts being looked up
+	pending map[string]bool
+	lookups chan struct{}
+}
+
+// NewResolver constructs a Resolver from the options and loads the hosts file
+func NewResolver(opts *Options) (*Resolver, error) {
+	r := &Resolver{
+		hostsFile:   opts.HostsFile,
+		cache:       map[string]resolverEntry{},
+		ttl:         opts.ResolveTTL,
+		negativeTTL: opts.ResolveNegativeTTL,
+		cacheSize:   opts.ResolveCacheSize,
+		hosts:       opts.ResolveHosts,
+		addrs:       opts.ResolveAddresses,
+		pending:     map[string]bool{},
+		lookups:     make(chan struct{}, resolveMaxLookups),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the hosts file again and clears the cache. On error the
+// current entries are kept
+func (r *Resolver) Reload() error {
+	names := map[string]string{}
+	addresses := map[string]string{}
+
+	if r.hostsFile != "" {
+		f, err := os.Open(r.hostsFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// Lines of "address name [alias...]" like /etc/hosts
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+				continue
+			}
+
+			if _, ok := addresses[fields[0]]; !ok {
+				addresses[fields[0]] = fields[1]
+			}
+			for _, name := range fields[1:] {
+				if _, ok := names[strings.ToLower(name)]; !ok {
+					names[strings.ToLower(name)] = fields[0]
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	r.Lock()
+	r.names = names
+	r.addresses = addresses
+	r.cache = map[string]resolverEntry{}
+	r.Unlock()
+
+	return nil
+}
+
+// Resolve returns the IP address of a host name or the name of an IP
+// address, depending on the configuration. The host is returned unchanged,
+// when it cannot be resolved or is not cached yet. Expired entries are
+// returned, while they are looked up again
+func (r *Resolver) Resolve(host string) string {
+	if r == nil || host == "" || host == "-" {
+		return host
+	}
+
+	isAddress := net.ParseIP(host) != nil
+	if (isAddress && !r.addrs) || (!isAddress && !r.hosts) {
+		return host
+	}
+
+	key := strings.ToLower(host)
+	now := time.Now()
+
+	r.Lock()
+	if isAddress {
+		if name, ok := r.addresses[host]; ok {
+			r.Unlock()
+			return name
+		}
+	} else if address, ok := r.names[key]; ok {
+		r.Unlock()
+		return address
+	}
+	entry, ok := r.cache[key]
+	if !ok || !now.Before(entry.expires) {
+		r.goLookup(key, host, isAddress)
+	}
+	r.Unlock()
+
+	if !ok || entry.value == "" {
+		return host
+	}
+	return entry.value
+}
+
+// Start looking up a host, unless it is looked up already or too many
+// lookups are running. Must be called with the lock held
+func (r *Resolver) goLookup(key string, host string, isAddress bool) {
+	if r.pending[key] {
+		return
+	}
+	select {
+	case r.lookups <- struct{}{}:
+	default:
+		return
+	}
+	r.pending[key] = true
+
+	go func() {
+		value := r.lookup(host, isAddress)
+		now := time.Now()
+		entry := resolverEntry{value: value, expires: now.Add(r.ttl)}
+		if value == "" {
+			entry.expires = now.Add(r.negativeTTL)
+		}
+
+		r.Lock()
+		delete(r.pending, key)
+		r.add(key, entry, now)
+		r.Unlock()
+		<-r.lookups
+	}()
+}
+
+// Query DNS. Empty, when the lookup fails
+func (r *Resolver) lookup(host string, isAddress bool) string {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	if isAddress {
+		names, err := net.DefaultResolver.LookupAddr(ctx, host)
+		if err != nil || len(names) == 0 {
+			return ""
+		}
+		return strings.TrimSuffix(names[0], ".")
+	}
+
+	addresses, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return ""
+	}
+	// Prefer IPv4 addresses, which end up in device.ip
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			return address
+		}
+	}
+	return addresses[0]
+}
+
+// Add an entry to the cache. A full cache drops the expired entries first,
+// then arbitrary ones. Must be called with the lock held
+func (r *Resolver) add(key string, entry resolverEntry, now time.Time) {
+	if len(r.cache) >= r.cacheSize {
+		for k, e := range r.cache {
+			if now.After(e.expires) {
+				delete(r.cache, k)
+			}
+		}
+		for k := range r.cache {
+			if len(r.cache) < r.cacheSize {
+				break
+			}
+			delete(r.cache, k)
+		}
+	}
+
+	r.cache[key] = entry
+}
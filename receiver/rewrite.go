@@ -0,0 +1,204 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    rewrite.go
+//: details: Rewriting and masking of messages before they are forwarded
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hwahrmann/rsa-nw-syslog-receiver/syslog"
+)
+
+// Rewrite represents a step of the rewrite pipeline. The step is applied to
+// the messages matching its conditions
+type Rewrite struct {
+	Name        string
+	Type        string
+	Regex       string
+	Replacement string
+	Salt        string
+	MaxLength   int `yaml:"maxlength"`
+	Text        string
+	Condition   `yaml:",inline"`
+}
+
+// The types of rewrite steps
+const (
+	rewriteReplace  = "replace"
+	rewriteMask     = "mask"
+	rewriteHash     = "hash"
+	rewriteTruncate = "truncate"
+	rewritePrefix   = "prefix"
+	rewriteSuffix   = "suffix"
+)
+
+// Length of the hex encoded hash replacing a value
+const rewriteHashLength = 16
+
+type rewriteStep struct {
+	*condition
+	name        string
+	kind        string
+	regex       *regexp.Regexp
+	replacement string
+	salt        string
+	maxLength   int
+	text        string
+}
+
+// Rewrites applies the rewrite steps in order
+type Rewrites struct {
+	steps []*rewriteStep
+}
+
+// NewRewrites constructs the rewrite steps from the options
+func NewRewrites(rewrites []Rewrite) (*Rewrites, error) {
+	var err error
+
+	r := &Rewrites{}
+	for i, rewrite := range rewrites {
+		step := &rewriteStep{
+			name:        rewrite.Name,
+			kind:        rewrite.Type,
+			replacement: rewrite.Replacement,
+			salt:        rewrite.Salt,
+			maxLength:   rewrite.MaxLength,
+			text:        rewrite.Text,
+		}
+		if step.name == "" {
+			step.name = "rewrite" + strconv.Itoa(i+1)
+		}
+
+		switch step.kind {
+		case rewriteReplace, rewriteMask, rewriteHash:
+			if rewrite.Regex == "" {
+				return nil, fmt.Errorf("Rewrite %s: %s requires a regex", step.name, step.kind)
+			}
+			if step.regex, err = regexp.Compile(rewrite.Regex); err != nil {
+				return nil, fmt.Errorf("Rewrite %s: %s", step.name, err)
+			}
+		case rewriteTruncate:
+			if step.maxLength <= 0 {
+				return nil, fmt.Errorf("Rewrite %s: truncate requires a maxlength", step.name)
+			}
+		case rewritePrefix, rewriteSuffix:
+			if step.text == "" {
+				return nil, fmt.Errorf("Rewrite %s: %s requires a text", step.name, step.kind)
+			}
+		default:
+			return nil, fmt.Errorf("Rewrite %s: unknown type %q", step.name, rewrite.Type)
+		}
+
+		if step.condition, err = newCondition(rewrite.Condition); err != nil {
+			return nil, fmt.Errorf("Rewrite %s: %s", step.name, err)
+		}
+
+		r.steps = append(r.steps, step)
+	}
+
+	return r, nil
+}
+
+// Apply rewrites the message. trace, if not nil, is called with the message
+// after each applied step. Truncation leaves room for the sample rate, which
+// is appended when the message is sent
+func (r *Rewrites) Apply(syslogmsg syslog.LogParts, message *Message, search *Search, trace func(name string, msg string)) {
+	reserve := len(sampleRateSuffix(message))
+	for _, step := range r.steps {
+		if !step.matches(syslogmsg, message, search) {
+			continue
+		}
+
+		message.Msg = step.apply(message.Msg, reserve)
+		if trace != nil {
+			trace(step.name, message.Msg)
+		}
+	}
+}
+
+func (step *rewriteStep) apply(msg string, reserve int) string {
+	switch step.kind {
+	case rewriteReplace:
+		return step.regex.ReplaceAllString(msg, step.replacement)
+	case rewriteMask:
+		return replaceValues(step.regex, msg, func(value string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(value))
+		})
+	case rewriteHash:
+		return replaceValues(step.regex, msg, func(value string) string {
+			sum := sha256.Sum256([]byte(step.salt + value))
+			return hex.EncodeToString(sum[:])[:rewriteHashLength]
+		})
+	case rewriteTruncate:
+		return truncate(msg, step.maxLength-reserve)
+	case rewritePrefix:
+		return step.text + msg
+	case rewriteSuffix:
+		return msg + step.text
+	}
+
+	return msg
+}
+
+// Replace the matches of a regex by the result of fn. When the regex has a
+// group, only the first group is replaced, which keeps e.g. "user=" of
+// "user=(\S+)"
+func replaceValues(regex *regexp.Regexp, msg string, fn func(value string) string) string {
+	var (
+		b    strings.Builder
+		last int
+	)
+
+	for _, match := range regex.FindAllStringSubmatchIndex(msg, -1) {
+		from, to := match[0], match[1]
+		if len(match) > 2 && match[2] >= 0 {
+			from, to = match[2], match[3]
+		}
+		b.WriteString(msg[last:from])
+		b.WriteString(fn(msg[from:to]))
+		last = to
+	}
+	b.WriteString(msg[last:])
+
+	return b.String()
+}
+
+// Truncate a message to at most max bytes, without splitting a UTF-8
+// encoded character
+func truncate(msg string, max int) string {
+	if len(msg) <= max {
+		return msg
+	}
+	if max < 0 {
+		max = 0
+	}
+
+	for max > 0 && !utf8.RuneStart(msg[max]) {
+		max--
+	}
+	return msg[:max]
+}
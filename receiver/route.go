@@ -0,0 +1,241 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    route.go
+//: details: Routing of events to Log Decoder destinations
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hwahrmann/rsa-nw-syslog-receiver/syslog"
+	"github.com/joncrlsn/dque"
+)
+
+// Destination represents a named Log Decoder
+type Destination struct {
+	Name               string
+	LogDecoder         string `yaml:"logdecoder"`
+	LogDecoderProtocol string `yaml:"logdecoderprotocol"`
+	Framing            string
+	Delimiter          string
+}
+
+// Route represents a rule directing events to a destination
+type Route struct {
+	Destination string
+	Condition   `yaml:",inline"`
+}
+
+// The destination given by logdecoder and logdecoderprotocol
+const defaultDestination = "default"
+
+var destinationName = regexp.MustCompile(`^[\w.-]+$`)
+
+// Appended to the queue name of a destination for its spool
+const spoolSuffix = "spool"
+
+// Each destination has its own queue and sender
+type destination struct {
+	name     string
+	address  string
+	protocol string
+	framing  string
+	// The delimiter of the non-transparent framing
+	delimiter string
+	queue     *dque.DQue
+	// The time the sender last made progress in Unix nanoseconds
+	heartbeat int64
+	// Events exceeding a rate limit with action spool
+	spool *dque.DQue
+}
+
+type routeRule struct {
+	*condition
+	destination *destination
+}
+
+// Routes selects the destination of events. The first matching route
+// decides, events matching no route go to the default destination
+type Routes struct {
+	destinations []*destination
+	rules        []routeRule
+}
+
+// NewRoutes constructs the destinations and routes from the options. The
+// queues are opened by open
+func NewRoutes(opts *Options) (*Routes, error) {
+	r := &Routes{}
+	names := map[string]*destination{}
+
+	destinations := append([]Destination{{
+		Name:               defaultDestination,
+		LogDecoder:         opts.LogDecoder,
+		LogDecoderProtocol: opts.LogDecoderProtocol,
+		Framing:            opts.LogDecoderFraming,
+		Delimiter:          opts.LogDecoderDelim,
+	}}, opts.Destinations...)
+	for _, d := range destinations {
+		if !destinationName.MatchString(d.Name) {
+			return nil, fmt.Errorf("Invalid destination name %q", d.Name)
+		}
+		if _, ok := names[d.Name]; ok {
+			return nil, fmt.Errorf("Duplicate destination %q", d.Name)
+		}
+		// The queue would be the spool of another destination
+		if strings.HasSuffix(d.Name, spoolSuffix) {
+			return nil, fmt.Errorf("Invalid destination name %q, must not end in %q", d.Name, spoolSuffix)
+		}
+		if d.LogDecoder == "" {
+			return nil, fmt.Errorf("Destination %s: logdecoder is required", d.Name)
+		}
+
+		protocol := d.LogDecoderProtocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			return nil, fmt.Errorf("Destination %s: unknown logdecoderprotocol %q", d.Name, protocol)
+		}
+
+		framing := d.Framing
+		if framing == "" {
+			framing = framingNonTransparent
+		}
+		if framing != framingNonTransparent && framing != framingOctetCounting {
+			return nil, fmt.Errorf("Destination %s: unknown framing %q", d.Name, framing)
+		}
+
+		delimiter := d.Delimiter
+		if delimiter == "" {
+			delimiter = "lf"
+		}
+		if _, ok := delimiters[strings.ToLower(delimiter)]; !ok {
+			return nil, fmt.Errorf("Destination %s: unknown delimiter %q", d.Name, delimiter)
+		}
+
+		dest := &destination{
+			name:      d.Name,
+			address:   decoderAddr(d.LogDecoder),
+			protocol:  protocol,
+			framing:   framing,
+			delimiter: delimiters[strings.ToLower(delimiter)],
+		}
+		names[d.Name] = dest
+		r.destinations = append(r.destinations, dest)
+	}
+
+	for i, route := range opts.Routes {
+		dest, ok := names[route.Destination]
+		if !ok {
+			return nil, fmt.Errorf("Route %d: unknown destination %q", i+1, route.Destination)
+		}
+
+		cond, err := newCondition(route.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("Route %d: %s", i+1, err)
+		}
+		r.rules = append(r.rules, routeRule{condition: cond, destination: dest})
+	}
+
+	return r, nil
+}
+
+// Open the queues of all destinations. The default destination keeps the
+// queue of versions without routing
+func (r *Routes) open(spool bool) error {
+	var err error
+
+	for _, d := range r.destinations {
+		name := queueName
+		if d.name != defaultDestination {
+			name = queueName + "-" + d.name
+		}
+
+		d.queue, err = dque.NewOrOpen(name, queueDir, queueSize, MessageBuilder)
+		if err != nil {
+			return fmt.Errorf("Destination %s: %s", d.name, err)
+		}
+		log.Infof("Queue Size of %s: %d", d.name, d.queue.Size())
+
+		if spool {
+			d.spool, err = dque.NewOrOpen(name+"-"+spoolSuffix, queueDir, queueSize, MessageBuilder)
+			if err != nil {
+				return fmt.Errorf("Destination %s: %s", d.name, err)
+			}
+			log.Infof("Spool Size of %s: %d", d.name, d.spool.Size())
+		}
+	}
+
+	return nil
+}
+
+// Returns the destination of an event
+func (r *Routes) route(syslogmsg syslog.LogParts, message *Message, search *Search) *destination {
+	for _, rule := range r.rules {
+		if rule.matches(syslogmsg, message, search) {
+			return rule.destination
+		}
+	}
+
+	return r.destinations[0]
+}
+
+// Size returns the number of queued events of all destinations
+func (r *Routes) Size() int {
+	size := 0
+	for _, d := range r.destinations {
+		size += d.size()
+	}
+
+	return size
+}
+
+// Sizes returns the number of queued events per destination
+func (r *Routes) Sizes() map[string]int {
+	sizes := map[string]int{}
+	for _, d := range r.destinations {
+		sizes[d.name] = d.size()
+	}
+
+	return sizes
+}
+
+// Returns the number of queued and spooled events
+func (d *destination) size() int {
+	if d.spool == nil {
+		return d.queue.Size()
+	}
+	return d.queue.Size() + d.spool.Size()
+}
+
+// Returns the event framed for the stream to the Log Decoder. Events sent
+// by UDP are not framed
+func (d *destination) frame(msg string) string {
+	if d.protocol != "tcp" {
+		return msg
+	}
+	if d.framing == framingOctetCounting {
+		return strconv.Itoa(len(msg)) + " " + msg
+	}
+	return msg + d.delimiter
+}
@@ -45,8 +45,16 @@ func main() {
 	// Retrieve the Optons
 	opts = GetOptions()
 
-	// Notify on SIGINT and SIGTERM
-	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	if opts.test {
+		if err := NewSyslogHandler().ruleTest(os.Stdin, os.Stdout); err != nil {
+			opts.Logger.Fatal(err)
+		}
+		return
+	}
+
+	// Notify on SIGINT and SIGTERM, SIGHUP reloads the relay map
+	// and hosts file
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	syslogHandler := NewSyslogHandler()
 
@@ -59,9 +67,16 @@ func main() {
 
 	go statsHTTPServer(syslogHandler)
 
-	<-signalCh
+	for sig := range signalCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		syslogHandler.reload()
+	}
 
 	opts.Logger.Info("Stopping Syslog Receiver")
 
 	syslogHandler.shutdown()
+
+	opts.pidRemove()
 }
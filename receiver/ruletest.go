@@ -0,0 +1,102 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    ruletest.go
+//: details: Test the configured rules with sample messages
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/hwahrmann/rsa-nw-syslog-receiver/syslog"
+)
+
+// Run the messages read from in, one per line, through the configured
+// rules and write the results to out instead of forwarding them
+func (h *SyslogHandler) ruleTest(in io.Reader, out io.Writer) error {
+	compilePatterns()
+
+	server := syslog.NewServer()
+	server.SetLocationFunc(h.timezones.ForClient)
+	server.SetUnixTimeUnit(timeUnit())
+	server.SetCharsetFunc(h.charsets.ForClient)
+	if opts.ListenFormat == listenFormatJSON {
+		server.SetFormat(syslog.FormatJSON)
+	}
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(out, "message:     %s\n", line)
+		syslogmsg, err := server.Parse(line, opts.testClient)
+		if err != nil {
+			fmt.Fprintf(out, "parse error: %s\n", err)
+		}
+
+		message, search := h.buildMessage(syslogmsg)
+		if search != nil {
+			name := search.Name
+			if name == "" {
+				name = search.Regex
+			}
+			fmt.Fprintf(out, "search:      %s\n", name)
+		}
+		fmt.Fprintf(out, "host:        %s\n", message.Host)
+
+		if rule := h.filters.match(syslogmsg, message, search); rule != nil {
+			fmt.Fprintf(out, "filter:      %s (%s)\n", rule.name, rule.action)
+			if rule.action == filterActionDrop {
+				fmt.Fprintln(out)
+				continue
+			}
+		}
+
+		host := message.Host
+		h.mapHost(syslogmsg, message)
+		if message.Host != host {
+			fmt.Fprintf(out, "mapped host: %s\n", message.Host)
+		}
+
+		if rule := h.sampling.match(syslogmsg, message, search); rule != nil {
+			kept := h.sampling.Keep(syslogmsg, message, search)
+			fmt.Fprintf(out, "sampling:    %s (kept: %t)\n", rule.name, kept)
+			if !kept {
+				fmt.Fprintln(out)
+				continue
+			}
+		}
+
+		d := h.routes.route(syslogmsg, message, search)
+		h.rewrites.Apply(syslogmsg, message, search, func(name string, msg string) {
+			fmt.Fprintf(out, "rewrite:     %s: %s\n", name, msg)
+		})
+
+		fmt.Fprintf(out, "destination: %s\n", d.name)
+		fmt.Fprintf(out, "forward:     %s\n\n", formatMessage(message))
+	}
+
+	return scanner.Err()
+}
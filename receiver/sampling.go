@@ -0,0 +1,176 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    sampling.go
+//: details: Deterministic sampling of high volume sources
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/hwahrmann/rsa-nw-syslog-receiver/syslog"
+)
+
+// Sample represents a rule to keep 1 in Rate of the matching events
+type Sample struct {
+	Name      string
+	Rate      uint64
+	Mode      string
+	Key       string
+	Condition `yaml:",inline"`
+}
+
+// SampleStats represents the kept and dropped events of a sampling rule
+type SampleStats struct {
+	Name    string
+	Rate    uint64
+	Kept    uint64
+	Dropped uint64
+}
+
+// The modes of a sampling rule
+const (
+	// Keep every Rate-th event
+	sampleModeCount = "count"
+	// Keep the events, whose key hashes to a multiple of Rate. Events with
+	// the same key are kept or dropped together
+	sampleModeHash = "hash"
+)
+
+type sampleRule struct {
+	*condition
+	name    string
+	rate    uint64
+	mode    string
+	key     *regexp.Regexp
+	count   uint64
+	kept    uint64
+	dropped uint64
+}
+
+// Sampling applies the first matching sampling rule
+type Sampling struct {
+	rules []*sampleRule
+}
+
+// NewSampling constructs the sampling rules from the options
+func NewSampling(samples []Sample) (*Sampling, error) {
+	var err error
+
+	s := &Sampling{}
+	for i, sample := range samples {
+		rule := &sampleRule{name: sample.Name, rate: sample.Rate, mode: sample.Mode}
+		if rule.name == "" {
+			rule.name = "sample" + strconv.Itoa(i+1)
+		}
+		if rule.rate == 0 {
+			return nil, fmt.Errorf("Sample %s: rate must be greater than 0", rule.name)
+		}
+
+		switch rule.mode {
+		case "":
+			rule.mode = sampleModeCount
+		case sampleModeCount:
+		case sampleModeHash:
+			if rule.key, err = compileOptional(sample.Key); err != nil {
+				return nil, fmt.Errorf("Sample %s: %s", rule.name, err)
+			}
+		default:
+			return nil, fmt.Errorf("Sample %s: unknown mode %q", rule.name, sample.Mode)
+		}
+
+		if rule.condition, err = newCondition(sample.Condition); err != nil {
+			return nil, fmt.Errorf("Sample %s: %s", rule.name, err)
+		}
+
+		s.rules = append(s.rules, rule)
+	}
+
+	return s, nil
+}
+
+// Keep returns false, when the event is to be dropped. Kept events are
+// annotated with the sampling rate
+func (s *Sampling) Keep(syslogmsg syslog.LogParts, message *Message, search *Search) bool {
+	rule := s.match(syslogmsg, message, search)
+	if rule == nil {
+		return true
+	}
+
+	if !rule.keep(message) {
+		atomic.AddUint64(&rule.dropped, 1)
+		return false
+	}
+
+	atomic.AddUint64(&rule.kept, 1)
+	message.SampleRate = strconv.FormatUint(rule.rate, 10)
+	return true
+}
+
+// Stats returns the kept and dropped events of the sampling rules
+func (s *Sampling) Stats() []SampleStats {
+	stats := []SampleStats{}
+	for _, rule := range s.rules {
+		stats = append(stats, SampleStats{
+			Name:    rule.name,
+			Rate:    rule.rate,
+			Kept:    atomic.LoadUint64(&rule.kept),
+			Dropped: atomic.LoadUint64(&rule.dropped),
+		})
+	}
+
+	return stats
+}
+
+// Returns the first matching rule or nil
+func (s *Sampling) match(syslogmsg syslog.LogParts, message *Message, search *Search) *sampleRule {
+	for _, rule := range s.rules {
+		if rule.matches(syslogmsg, message, search) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+func (rule *sampleRule) keep(message *Message) bool {
+	if rule.mode == sampleModeCount {
+		return (atomic.AddUint64(&rule.count, 1)-1)%rule.rate == 0
+	}
+
+	// Without a key regex or a match, the host and message are the key.
+	// With a group, the first group is the key
+	key := message.Host + "\x00" + message.Msg
+	if rule.key != nil {
+		if match := rule.key.FindStringSubmatch(message.Msg); match != nil {
+			key = match[0]
+			if len(match) > 1 {
+				key = match[1]
+			}
+		}
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return hash.Sum64()%rule.rate == 0
+}
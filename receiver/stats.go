@@ -26,6 +26,7 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -41,8 +42,10 @@ func statsHTTPServer(sysloghandler *SyslogHandler) {
 	mux.HandleFunc("/stats", StatsHandler(sysloghandler))
 	mux.HandleFunc("/stats/events", StatsHandlerEvents(sysloghandler))
 	mux.HandleFunc("/stats/queue", StatsHandlerQueue(sysloghandler))
+	mux.HandleFunc("/stats/filters", StatsHandlerFilters(sysloghandler))
+	mux.HandleFunc("/stats/ratelimits", StatsHandlerRateLimits(sysloghandler))
 
-	addr := net.JoinHostPort("0.0.0.0", strconv.Itoa(opts.StatsHTTPPort))
+	addr := net.JoinHostPort(strings.Trim(opts.StatsAddress, "[]"), strconv.Itoa(opts.StatsHTTPPort))
 
 	opts.Logger.Info("starting stats web server ...")
 	err := http.ListenAndServe(addr, mux)
@@ -96,3 +99,35 @@ func StatsHandlerQueue(h *SyslogHandler) http.HandlerFunc {
 		}
 	}
 }
+
+// StatsHandlerFilters returns the hits of the filter rules as part of the REST call
+func StatsHandlerFilters(h *SyslogHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var stats = h.status()
+
+		j, err := json.Marshal(stats.Filters)
+		if err != nil {
+			opts.Logger.Info(err)
+		}
+
+		if _, err = w.Write(j); err != nil {
+			opts.Logger.Info(err)
+		}
+	}
+}
+
+// StatsHandlerRateLimits returns the throttled events of the rate limits as part of the REST call
+func StatsHandlerRateLimits(h *SyslogHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var stats = h.status()
+
+		j, err := json.Marshal(stats.RateLimits)
+		if err != nil {
+			opts.Logger.Info(err)
+		}
+
+		if _, err = w.Write(j); err != nil {
+			opts.Logger.Info(err)
+		}
+	}
+}
@@ -23,9 +23,12 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -42,13 +45,38 @@ type SyslogHandler struct {
 	workers        int
 	stats          SyslogStats
 	pool           chan chan struct{}
+	workerWait     sync.WaitGroup
+	timezones      *Timezones
+	trustedProxies []*net.IPNet
+	relayMap       *RelayMap
+	resolver       *Resolver
+	filters        *Filters
+	routes         *Routes
+	rewrites       *Rewrites
+	rateLimits     *RateLimits
+	sampling       *Sampling
+	dedup          *Dedup
+	repeats        *Repeats
+	multilines     *Multilines
+	charsets       *Charsets
+	// The number of workers processing an event
+	busy int32
+	// The events at the last liveness check
+	lastEvents uint64
 }
 
 // SyslogStats represents syslogreceiver stats
 type SyslogStats struct {
 	QueueCount int
+	Queues     map[string]int
 	Events     uint64
+	Dropped    uint64
+	Duplicates uint64
+	Repeated   uint64
 	Workers    int
+	Filters    []FilterStats
+	Sampling   []SampleStats
+	RateLimits []RateLimitStats
 }
 
 var (
@@ -57,24 +85,37 @@ var (
 	syslogMsgCH = make(chan syslog.LogParts)
 	stopSender  = make(chan struct{})
 
-	server   syslog.Server
+	server   *syslog.Server
 	patterns []*regexp.Regexp
-
-	queue *dque.DQue
 )
 
 const (
 	queueName = "syslogreceiver"
 
+	defaultPriority = "13"
+
 	queueDir  = "/tmp"
 	queueSize = 100
+
+	// Bounds connecting to a Log Decoder, so the watchdog is not tripped
+	dialTimeout = 5 * time.Second
 )
 
 // Message is what we'll be storing in the queue.
 type Message struct {
-	Time string
-	Host string
-	Msg  string
+	Time        string
+	Host        string
+	Msg         string
+	ReceiveTime string
+	DeviceType  string
+	// The parsed metadata. Priority is empty for messages queued by
+	// versions without it
+	Priority string
+	Tag      string
+	PID      string
+	Client   string
+	// The sampling rate of sampled messages, otherwise empty
+	SampleRate string
 }
 
 // MessageBuilder creates a new Message and returns a pointer to it.
@@ -87,68 +128,181 @@ func MessageBuilder() interface{} {
 func NewSyslogHandler() *SyslogHandler {
 	log = opts.Logger
 
+	// Options have been validated already
+	timezones, _ := NewTimezones(opts)
+	trustedProxies, _ := parseCIDRs(opts.TrustedProxies)
+	filters, _ := NewFilters(opts.Filters)
+	routes, _ := NewRoutes(opts)
+	rewrites, _ := NewRewrites(opts.Rewrites)
+	rateLimits, _ := NewRateLimits(opts.RateLimits)
+	sampling, _ := NewSampling(opts.Sampling)
+	multilines, _ := NewMultilines(opts.Multiline)
+	charsets, _ := NewCharsets(opts)
+	var repeats *Repeats
+	if opts.CollapseRepeats {
+		repeats = NewRepeats(opts.RepeatFlush)
+	}
+	var relayMap *RelayMap
+	if opts.RelayMap != "" {
+		relayMap, _ = NewRelayMap(opts.RelayMap)
+	}
+	var resolver *Resolver
+	if opts.ResolveHosts || opts.ResolveAddresses {
+		resolver, _ = NewResolver(opts)
+	}
+
 	return &SyslogHandler{
 		listenPort:     opts.ListenPort,
 		listenProtocol: opts.Protocol,
 		logdecoder:     opts.LogDecoder,
 		workers:        opts.Workers,
 		pool:           make(chan chan struct{}, maxWorkers),
+		timezones:      timezones,
+		trustedProxies: trustedProxies,
+		relayMap:       relayMap,
+		resolver:       resolver,
+		filters:        filters,
+		routes:         routes,
+		rewrites:       rewrites,
+		rateLimits:     rateLimits,
+		sampling:       sampling,
+		dedup:          NewDedup(opts.DedupWindow, opts.DedupSize),
+		repeats:        repeats,
+		multilines:     multilines,
+		charsets:       charsets,
 	}
 }
 
 func (h *SyslogHandler) status() *SyslogStats {
 	return &SyslogStats{
-		QueueCount: queue.Size(),
+		QueueCount: h.routes.Size(),
+		Queues:     h.routes.Sizes(),
 		Events:     atomic.LoadUint64(&h.stats.Events),
+		Dropped:    atomic.LoadUint64(&h.stats.Dropped),
+		Duplicates: atomic.LoadUint64(&h.stats.Duplicates),
+		Repeated:   atomic.LoadUint64(&h.stats.Repeated),
 		Workers:    h.workers,
+		Filters:    h.filters.Stats(),
+		Sampling:   h.sampling.Stats(),
+		RateLimits: h.rateLimits.Stats(),
 	}
 }
 
-func (h *SyslogHandler) run() error {
-
-	var (
-		err error
-		p   *regexp.Regexp
-	)
+// Returns an error, when the workers or senders make no progress. All
+// workers busy without a new event since the last check or a sender without
+// heartbeat for the watchdog interval are considered hung
+func (h *SyslogHandler) alive(interval time.Duration) error {
+	events := atomic.LoadUint64(&h.stats.Events)
+	stuck := atomic.LoadInt32(&h.busy) == int32(h.workers) && events == h.lastEvents
+	h.lastEvents = events
+	if stuck {
+		return errors.New("All workers are busy without progress")
+	}
 
-	// Compile the Regex Pattern
-	for _, search := range opts.Search {
-		p, err = regexp.Compile(search.Regex)
-		if err == nil {
-			patterns = append(patterns, p)
+	for _, d := range h.routes.destinations {
+		if time.Since(time.Unix(0, atomic.LoadInt64(&d.heartbeat))) > interval {
+			return fmt.Errorf("Sender for %s made no progress for %s", d.name, interval)
 		}
 	}
 
-	// Create the Queue to store the messages
-	queue, err = dque.NewOrOpen(queueName, queueDir, queueSize, MessageBuilder)
-	if err != nil {
+	return nil
+}
+
+func (h *SyslogHandler) run() error {
+
+	var err error
+
+	compilePatterns()
+
+	// Create the Queues to store the messages
+	if err = h.routes.open(h.rateLimits.Spooling()); err != nil {
 		log.Fatal("Error creating new dque ", err)
 	}
-	log.Infof("Queue Size: %d", queue.Size())
 
 	// Start the Receiver Workers
+	h.workerWait.Add(h.workers)
 	for i := 0; i < h.workers; i++ {
 		go func() {
+			defer h.workerWait.Done()
 			wQuit := make(chan struct{})
 			h.pool <- wQuit
 			h.syslogWorker(wQuit)
 		}()
 	}
 
-	// Start the Sender
-	go syslogSender(queue)
+	// Start the Senders
+	for _, d := range h.routes.destinations {
+		go syslogSender(d)
+	}
+
+	if h.repeats != nil {
+		go h.repeatFlushLoop()
+	}
 
 	// Setup the Syslog Server
 	channel := make(syslog.LogPartsChannel)
 	handler := syslog.NewChannelHandler(channel)
 
-	addr := "0.0.0.0:" + strconv.Itoa(h.listenPort)
-	server := syslog.NewServer()
+	server = syslog.NewServer()
 	server.SetHandler(handler)
-	if h.listenProtocol == "udp" {
-		server.ListenUDP(addr)
+	server.SetLocationFunc(h.timezones.ForClient)
+	server.SetAdmitFunc(h.admit)
+	server.SetUnixTimeUnit(timeUnit())
+	if opts.ListenFormat == listenFormatJSON {
+		server.SetFormat(syslog.FormatJSON)
+	}
+	server.SetCharsetFunc(h.charsets.ForClient)
+	if len(opts.Multiline) > 0 {
+		server.SetMultilineFunc(h.multilines.ForClient)
+	}
+
+	// Prefer the sockets passed by systemd socket activation, which allows
+	// binding privileged ports without running as root
+	listeners, connections, err := sdListenFds()
+	if err != nil {
+		log.Errorf("Error using sockets passed by systemd: %s", err)
+		return errors.New("Error starting Syslog Server")
+	}
+	if len(listeners)+len(connections) > 0 {
+		for _, listener := range listeners {
+			if proxyListener(listener.Addr().String()) {
+				listener = syslog.NewProxyListener(listener, h.trustedProxies)
+			}
+			server.AddListener(listener)
+		}
+		for _, connection := range connections {
+			server.AddPacketConn(connection)
+		}
+		log.Infof("Using %d socket(s) passed by systemd", len(listeners)+len(connections))
 	} else {
-		server.ListenTCP(addr)
+		for _, addr := range listenAddrs(h.listenPort) {
+			if h.listenProtocol == "udp" {
+				err = server.ListenUDP(addr)
+			} else if proxyListener(addr) {
+				err = server.ListenTCPProxy(addr, h.trustedProxies)
+			} else {
+				err = server.ListenTCP(addr)
+			}
+			if err != nil {
+				log.Errorf("Error listening on %s/%s: %s", addr, h.listenProtocol, err)
+				return errors.New("Error starting Syslog Server")
+			}
+		}
+	}
+
+	if opts.GELFPort > 0 {
+		for _, addr := range listenAddrs(opts.GELFPort) {
+			if opts.GELFProtocol == "tcp" {
+				err = server.ListenGELFTCP(addr)
+			} else {
+				err = server.ListenGELFUDP(addr)
+			}
+			if err != nil {
+				log.Errorf("Error starting GELF listener: %s", err)
+				return errors.New("Error starting Syslog Server")
+			}
+			log.Infof("GELF listener is running (listening on %s/%s)", addr, opts.GELFProtocol)
+		}
 	}
 
 	err = server.Boot()
@@ -157,6 +311,11 @@ func (h *SyslogHandler) run() error {
 		return errors.New("Error starting Syslog Server")
 	}
 
+	if err = sdNotify(sdReady); err != nil {
+		log.Errorf("Error sending readiness notification: %s", err)
+	}
+	go sdWatchdogLoop(h.alive)
+
 	// Start receiver thread
 	go func(channel syslog.LogPartsChannel) {
 		for logParts := range channel {
@@ -164,25 +323,63 @@ func (h *SyslogHandler) run() error {
 		}
 	}(channel)
 
-	log.Infof("Syslog Receiver is running (listening on [::]:%d/%s workers#: %d)", h.listenPort, h.listenProtocol, h.workers)
+	log.Infof("Syslog Receiver is running (listening on %s/%s workers#: %d)", strings.Join(listenAddrs(h.listenPort), ","), h.listenProtocol, h.workers)
 
 	server.Wait()
 
 	return nil
 }
 
+// Compile the Regex Patterns of the searches
+func compilePatterns() {
+	for _, search := range opts.Search {
+		p, err := regexp.Compile(search.Regex)
+		if err == nil {
+			patterns = append(patterns, p)
+		}
+	}
+}
+
+// Reload the relay map and the hosts file
+func (h *SyslogHandler) reload() {
+	if h.relayMap != nil {
+		if err := h.relayMap.Reload(); err != nil {
+			log.Errorf("Error reloading relay map, keeping the current mappings: %s", err)
+		} else {
+			log.Infof("Reloaded relay map with %d mappings", h.relayMap.Size())
+		}
+	}
+
+	if h.resolver != nil {
+		if err := h.resolver.Reload(); err != nil {
+			log.Errorf("Error reloading hosts file, keeping the current entries: %s", err)
+		} else {
+			log.Info("Reloaded hosts file and cleared the DNS cache")
+		}
+	}
+}
+
 // Shutdown the Syslog Receiver
 func (h *SyslogHandler) shutdown() {
-	log.Infof("Workers received %d messages", &h.stats.Events)
 	log.Info("Stopping syslog server service gracefully ...")
+	sdNotify(sdStopping)
+	close(stopWatchdog)
+
+	// Stop the server first, while the workers still take the pending
+	// multiline events it flushes
+	if server != nil {
+		server.Kill()
+	}
 	for i := 0; i < h.workers; i++ {
-		wQuit := h.pool
-		close(wQuit)
+		close(<-h.pool)
 	}
-	server.Kill()
-	log.Info("Syslogreceiver has been shutdown")
-	close(syslogMsgCH)
+	h.workerWait.Wait()
+	log.Infof("Workers received %d messages", atomic.LoadUint64(&h.stats.Events))
+
+	// syslogMsgCH is left open, as events of connections still open
+	// may arrive until the process exits
 	close(stopSender)
+	log.Info("Syslogreceiver has been shutdown")
 }
 
 // Worker, which receives Syslog Events and Queues the message
@@ -197,25 +394,366 @@ LOOP:
 
 		select {
 		case <-wQuit:
-			break LOOP
+			// Take an event already waiting, e.g. flushed on stop
+			select {
+			case syslogmsg, ok = <-syslogMsgCH:
+				if !ok {
+					break LOOP
+				}
+			default:
+				break LOOP
+			}
 		case syslogmsg, ok = <-syslogMsgCH:
 			if !ok {
 				break LOOP
 			}
 		}
 
+		atomic.AddInt32(&h.busy, 1)
 		atomic.AddUint64(&h.stats.Events, 1)
+		h.process(syslogmsg)
+		atomic.AddInt32(&h.busy, -1)
+	}
+}
+
+// Apply the client rate limits to a received event, before it is parsed.
+// Returns false, when the event is dropped. The returned log parts record
+// the taken tokens and whether the event is spooled
+func (h *SyslogHandler) admit(client string) (syslog.LogParts, bool) {
+	action, ok, taken := h.rateLimits.AllowClient(client, time.Now())
+	switch {
+	case taken:
+		return syslog.LogParts{logPartsRateTokens: true}, true
+	case !ok && action == rateLimitSpool:
+		return syslog.LogParts{logPartsRateSpool: true}, true
+	}
+	return nil, ok
+}
+
+// Filter, sample and deduplicate an event and forward it
+func (h *SyslogHandler) process(syslogmsg syslog.LogParts) {
+	message, search := h.buildMessage(syslogmsg)
+	if !h.filters.Keep(syslogmsg, message, search) {
+		atomic.AddUint64(&h.stats.Dropped, 1)
+		return
+	}
+	h.mapHost(syslogmsg, message)
+
+	if !h.sampling.Keep(syslogmsg, message, search) {
+		return
+	}
+
+	now := time.Now()
+	if h.dedup.Duplicate(message, now) {
+		atomic.AddUint64(&h.stats.Duplicates, 1)
+		return
+	}
+
+	repeated, summary := h.repeats.Check(syslogmsg, message, search, now)
+	if summary != nil {
+		h.forward(summary)
+	}
+	if repeated {
+		atomic.AddUint64(&h.stats.Repeated, 1)
+		return
+	}
+
+	tokens, _ := syslogmsg[logPartsRateTokens].(bool)
+	spool, _ := syslogmsg[logPartsRateSpool].(bool)
+	h.forward(&pendingMessage{syslogmsg: syslogmsg, message: message, search: search, tokens: tokens, spool: spool})
+}
 
-		time := strconv.FormatInt(syslogmsg["timestamp"].(time.Time).Unix(), 10)
-		host := syslogmsg["hostname"].(string)
-		msg := syslogmsg["content"].(string)
-		// Add an item to the queue
-		if err := queue.Enqueue(&Message{time, host, msg}); err != nil {
-			log.Fatal("Error enqueueing item ", err)
+// Apply the host and global rate limits, route and rewrite a message and
+// queue it. The tokens taken by the client rate limits are given back, when
+// the message is throttled
+func (h *SyslogHandler) forward(p *pendingMessage) {
+	ok := !p.spool
+	if ok {
+		var action string
+		if action, ok = h.rateLimits.Allow(p.message, time.Now()); !ok && p.tokens {
+			h.rateLimits.Refund(p.message.Client)
 		}
+		if !ok && action != rateLimitSpool {
+			return
+		}
+	}
+
+	// Rewrite after routing, so routes see the original message
+	d := h.routes.route(p.syslogmsg, p.message, p.search)
+	h.rewrites.Apply(p.syslogmsg, p.message, p.search, nil)
+
+	// Add an item to the queue of the destination. Throttled events
+	// are spooled and sent, when the queue is empty
+	q := d.queue
+	if !ok {
+		q = d.spool
+	}
+	if err := q.Enqueue(p.message); err != nil {
+		log.Fatal("Error enqueueing item ", err)
 	}
 }
 
+// Forward the counts of repeated messages, which are not followed by
+// another message of their host
+func (h *SyslogHandler) repeatFlushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopSender:
+			return
+		case now := <-ticker.C:
+			for _, summary := range h.repeats.Expired(now) {
+				h.forward(summary)
+			}
+		}
+	}
+}
+
+// Extract the original sender, message and event time. The Search, which
+// matched, is returned as well
+func (h *SyslogHandler) buildMessage(syslogmsg syslog.LogParts) (*Message, *Search) {
+	var (
+		search *Search
+		m      map[string]string
+	)
+
+	// As a fallback the message and host as received by the relay is stored
+	host := syslogmsg["hostname"].(string)
+	msg := syslogmsg["content"].(string)
+	ts := syslogmsg["timestamp"].(time.Time)
+	local, _ := syslogmsg["timestamp_local"].(bool)
+	received, ok := syslogmsg["received"].(time.Time)
+	if !ok {
+		received = time.Now()
+	}
+
+	// extract sender and original message
+	fromClient, _ := syslogmsg["hostname_from_client"].(bool)
+	for i, pattern := range patterns {
+		// The parser has already extracted the host of standard messages,
+		// the default regexes are only needed for the others
+		if opts.Search[i].builtin && !fromClient {
+			if message, ok := syslogmsg["message"].(string); ok {
+				msg = message
+			}
+			break
+		}
+
+		if opts.Search[i].Type == searchTypeJSON {
+			m = findJSONMatches(&opts.Search[i], pattern, syslogmsg)
+		} else if matches := pattern.FindAllStringSubmatch(msg, -1); matches != nil {
+			m = findNamedMatches(pattern, matches)
+		}
+		if m == nil {
+			continue
+		}
+
+		search = &opts.Search[i]
+		if search.Type != searchTypeJSON {
+			host = m["host"]
+			msg = m["message"]
+			break
+		}
+
+		// Keep the fallbacks for paths not present in the JSON object
+		if m["host"] != "" {
+			host = m["host"]
+		}
+		msg = m["message"]
+		if t, ok := parseJSONTime(m["time"]); ok && search.EventTime != eventTimeCustom {
+			ts = t
+			local = false
+		}
+		break
+	}
+
+	// CEF and LEEF events name the original sender in their extension
+	extensions, _ := syslogmsg["extensions"].(map[string]string)
+	for _, field := range opts.HostFields {
+		if value := extensions[field]; value != "" {
+			host = value
+			break
+		}
+	}
+
+	// Apply a host specific time zone to a timestamp without zone information
+	location := h.timezones.ForHost(host)
+	if local && location != nil {
+		ts = inLocation(ts, location)
+	}
+
+	client, _ := syslogmsg["client"].(string)
+
+	if t, ok := parseUnixTime(m["unixtime"]); ok {
+		ts = t
+	}
+
+	// The device clock is wrong, use the receive time. Checked after the
+	// host time zone has been applied
+	if skew := received.Sub(ts); opts.MaxClockSkew > 0 && (skew > opts.MaxClockSkew || -skew > opts.MaxClockSkew) {
+		ts = received
+	}
+	eventtime := formatTime(ts)
+
+	// Messages without PRI are user-level notices
+	message := &Message{Time: eventtime, Host: host, Msg: msg, DeviceType: deviceType(syslogmsg)}
+	message.Priority = defaultPriority
+	if priority, ok := syslogmsg["priority"].(int); ok {
+		message.Priority = strconv.Itoa(priority)
+	}
+	message.Tag, _ = syslogmsg["tag"].(string)
+	message.PID, _ = syslogmsg["pid"].(string)
+	message.Client = client
+	if search == nil {
+		return message, nil
+	}
+
+	switch search.EventTime {
+	case eventTimeReceive:
+		message.Time = formatTime(received)
+	case eventTimeCustom:
+		if location == nil {
+			location = h.timezones.ForClient(client)
+		}
+		// Keep the device time, when the time group does not parse
+		if t, err := time.ParseInLocation(search.TimeLayout, m["time"], location); err == nil {
+			message.Time = formatTime(syslog.InferYear(t))
+		}
+	}
+
+	if search.ReceiveTime {
+		message.ReceiveTime = formatTime(received)
+	}
+
+	return message, search
+}
+
+// Replace the extracted host by the device configured in the relay map and
+// resolve it. Done after filtering, so dropped events cost no lookups
+func (h *SyslogHandler) mapHost(syslogmsg syslog.LogParts, message *Message) {
+	// Short host names behind relays cannot be resolved by the Log Decoder
+	if net.ParseIP(message.Host) == nil {
+		tag, _ := syslogmsg["tag"].(string)
+		if tag == "" {
+			tag, _ = syslogmsg["app_name"].(string)
+		}
+		if device, ok := h.relayMap.Lookup(message.Client, message.Host, tag); ok {
+			message.Host = device
+		}
+	}
+	message.Host = h.resolver.Resolve(message.Host)
+}
+
+// Returns the device type configured for the vendor and product of a CEF or
+// LEEF event. Empty, when none is configured
+func deviceType(syslogmsg syslog.LogParts) string {
+	vendor, _ := syslogmsg["vendor"].(string)
+	product, _ := syslogmsg["product"].(string)
+	if vendor == "" {
+		return ""
+	}
+
+	if t, ok := opts.DeviceTypes[vendor+"|"+product]; ok {
+		return t
+	}
+	return opts.DeviceTypes[vendor]
+}
+
+// Returns the PRI of a queued message. Messages received without one and
+// messages queued by versions without it get the default of RFC3164
+// section 4.3.3, user-level notice
+func messagePriority(message *Message) string {
+	if message.Priority == "" {
+		return defaultPriority
+	}
+	return message.Priority
+}
+
+// Returns the configured unit of Unix times
+func timeUnit() time.Duration {
+	if opts.TimeUnit == timeUnitMilliseconds {
+		return time.Millisecond
+	}
+	return time.Second
+}
+
+// Format a time as Unix time in the configured unit
+func formatTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(timeUnit()), 10)
+}
+
+// Parse a Unix time extracted from a message. Seconds may have a fraction.
+// Values beyond the year 5000 in seconds are taken as milliseconds
+func parseUnixTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if strings.Contains(value, ".") {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(seconds*float64(time.Second))), true
+	}
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return syslog.UnixTime(unix, time.Second), true
+}
+
+// Map the JSON paths of a search to the same names as the regex groups.
+// nil is returned, when the event is not JSON or the regex does not match
+func findJSONMatches(search *Search, regex *regexp.Regexp, syslogmsg syslog.LogParts) map[string]string {
+	doc, ok := syslogmsg["json"].(map[string]interface{})
+	if !ok || !regex.MatchString(syslogmsg["content"].(string)) {
+		return nil
+	}
+
+	results := map[string]string{}
+	if value, ok := syslog.LookupJSON(doc, search.HostPath); ok {
+		results["host"] = syslog.JSONString(value)
+	}
+	if value, ok := syslog.LookupJSON(doc, search.TimePath); ok {
+		results["time"] = syslog.JSONString(value)
+	}
+
+	// Without a message path the whole JSON object is forwarded
+	results["message"], _ = syslogmsg["json_text"].(string)
+	if value, ok := syslog.LookupJSON(doc, search.MessagePath); ok && search.MessagePath != "" {
+		results["message"] = syslog.JSONString(value)
+	}
+
+	return results
+}
+
+// Parse a JSON time given as RFC3339 string or as Unix time in seconds or
+// milliseconds
+func parseJSONTime(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// Unix time in milliseconds has more than 11 digits until the year 5138
+	if f > 1e11 {
+		f /= 1e3
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
+
 // Map all the Submatches
 func findNamedMatches(regex *regexp.Regexp, matches [][]string) map[string]string {
 	results := map[string]string{}
@@ -225,38 +763,36 @@ func findNamedMatches(regex *regexp.Regexp, matches [][]string) map[string]strin
 	return results
 }
 
-// This Worker extracts messages from the queue and sends them to RSA Netwitness
-func syslogSender(queue *dque.DQue) {
+// This Worker extracts messages from the queue of a destination and sends
+// them to RSA Netwitness
+func syslogSender(d *destination) {
 	var (
-		conn      net.Conn
-		err       error
-		iface     interface{}
-		msg       string
-		orighost  string
-		origmsg   string
-		eventtime string
+		conn  net.Conn
+		err   error
+		iface interface{}
 	)
 
-	log.Infof("Starting Syslog Sender with a Queue Size of %d", queue.Size())
+	log.Infof("Starting Syslog Sender for %s with a Queue Size of %d", d.name, d.queue.Size())
+	atomic.StoreInt64(&d.heartbeat, time.Now().UnixNano())
 	//Setup network connection
-	host := opts.LogDecoder + ":514"
-	if opts.LogDecoderProtocol == "udp" {
+	host := d.address
+	if d.protocol == "udp" {
 		conn, err = net.Dial("udp", host)
 		if err != nil {
 			log.Errorf("Worker could not connect to log decoder: %s\n", err)
 			return
 		}
 	} else {
-		conn, err = net.Dial("tcp", host)
+		conn, err = net.DialTimeout("tcp", host, dialTimeout)
 		if err != nil {
 			log.Errorf("Worker could not connect to log decoder: %s\n", err)
 			log.Info("Leaving Sylog Sender")
-			go checkConnection()
+			go checkConnection(d)
 			return
 		}
 	}
 	defer conn.Close()
-	log.Infof("Worker opened connection to %s/%s\n", opts.LogDecoderProtocol, host)
+	log.Infof("Worker opened connection to %s/%s\n", d.protocol, host)
 
 LOOP:
 	for {
@@ -265,10 +801,17 @@ LOOP:
 			log.Info("Stopping Syslog Sender")
 			break LOOP
 		default:
+			atomic.StoreInt64(&d.heartbeat, time.Now().UnixNano())
+
 			// Dequeue the next message in the queue
-			if iface, err = queue.Dequeue(); err != nil && err != dque.ErrEmpty {
+			if iface, err = d.queue.Dequeue(); err != nil && err != dque.ErrEmpty {
 				log.Fatal("Error dequeuing item:", err)
 			}
+			if err == dque.ErrEmpty && d.spool != nil {
+				if iface, err = d.spool.Dequeue(); err != nil && err != dque.ErrEmpty {
+					log.Fatal("Error dequeuing item:", err)
+				}
+			}
 
 			// On an empty queue sleeLogpartsp 1 second before rerying
 			if err == dque.ErrEmpty {
@@ -276,62 +819,87 @@ LOOP:
 				continue
 			}
 
-			message := iface.(*Message)
-
-			// As a fallback the message and host as received by the relay is stored
-			msg = message.Msg
-			orighost = message.Host
-			origmsg = msg
-			eventtime = message.Time
-
-			// extract sender and original message
-			var (
-				m       map[string]string
-				matches [][]string
-			)
-
-			for _, pattern := range patterns {
-				matches = pattern.FindAllStringSubmatch(msg, -1)
-				if matches != nil {
-					m = findNamedMatches(pattern, matches)
-					orighost = m["host"]
-					origmsg = m["message"]
-					if unixtime, ok := m["unixtime"]; ok {
-						eventtime = unixtime
-					}
-					break
-				}
-			}
-
-			msg := "[][][" + orighost + "][" + eventtime + "][]" + origmsg
-			if opts.LogDecoderProtocol == "tcp" {
-				msg = msg + "\n"
-			}
+			msg := d.frame(formatMessage(iface.(*Message)))
 			_, err = conn.Write([]byte(msg))
 			if err != nil {
 				log.Errorf("worker could not write to log decoder: %s\n", err)
 				// Check for decoder coming up again and leave worker
-				go checkConnection()
+				go checkConnection(d)
 				break LOOP
 			}
 		}
 	}
 }
 
+// Format a message with the header for the Log Decoder
+func formatMessage(message *Message) string {
+	msg := "[][" + message.ReceiveTime + "][" + message.Host + "][" + message.Time + "][" + message.DeviceType + "]" + message.Msg
+	if opts.EmitPriority {
+		msg = "<" + messagePriority(message) + ">" + msg
+	}
+	return controlChars(msg + sampleRateSuffix(message))
+}
+
+// Returns the annotation of sampled messages or an empty string
+func sampleRateSuffix(message *Message) string {
+	if message.SampleRate == "" {
+		return ""
+	}
+	return " sample_rate=" + message.SampleRate
+}
+
+// Returns the addresses to listen on for the given port. Without configured
+// addresses, the wildcard address is used, which is dual-stack on Linux
+func listenAddrs(port int) []string {
+	if len(opts.ListenAddresses) == 0 {
+		return []string{net.JoinHostPort("", strconv.Itoa(port))}
+	}
+
+	var addrs []string
+	for _, address := range opts.ListenAddresses {
+		addrs = append(addrs, net.JoinHostPort(strings.Trim(address, "[]"), strconv.Itoa(port)))
+	}
+	return addrs
+}
+
+// Returns true, when connections to the listener may start with a PROXY
+// protocol header. Without proxylisteners all TCP listeners expect it
+func proxyListener(addr string) bool {
+	if !opts.ProxyProtocol {
+		return false
+	}
+	if len(opts.ProxyListeners) == 0 {
+		return true
+	}
+
+	for _, listener := range opts.ProxyListeners {
+		if matchListener(listener, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the address of a Log Decoder. IPv6 addresses may be given with
+// or without brackets
+func decoderAddr(logdecoder string) string {
+	return net.JoinHostPort(strings.Trim(logdecoder, "[]"), "514")
+}
+
 // Check for Log Decoder capturing again
-func checkConnection() {
-	log.Info("Starting connection check for Log Decoder")
-	host := opts.LogDecoder + ":514"
+func checkConnection(d *destination) {
+	log.Infof("Starting connection check for Log Decoder %s", d.name)
+	host := d.address
 	for {
-		tcpAddr, _ := net.ResolveTCPAddr("tcp", host)
-		conn, err := net.DialTCP("tcp", nil, tcpAddr)
+		atomic.StoreInt64(&d.heartbeat, time.Now().UnixNano())
+		conn, err := net.DialTimeout("tcp", host, dialTimeout)
 		if err != nil {
 			time.Sleep(5000 * time.Millisecond)
 			continue
 		}
 		conn.Close()
 		// Start Sender Worker
-		go syslogSender(queue)
+		go syslogSender(d)
 		log.Info("Log Decoder capture interface up")
 		break
 	}
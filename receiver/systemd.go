@@ -0,0 +1,161 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    systemd.go
+//: details: systemd integration. Readiness notification, watchdog and
+//:          socket activation
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// The first file descriptor passed by systemd socket activation
+	sdListenFdsStart = 3
+
+	sdReady    = "READY=1"
+	sdStopping = "STOPPING=1"
+	sdWatchdog = "WATCHDOG=1"
+)
+
+var stopWatchdog = make(chan struct{})
+
+// Send a state notification to systemd. Without NOTIFY_SOCKET set, e.g. when
+// not started by systemd or with Type=simple, this is a no-op
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Abstract namespace sockets are announced with a leading "@"
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Returns the interval in which systemd expects a watchdog keep-alive.
+// Zero is returned, when the watchdog is not enabled for this process
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Send watchdog keep-alives at half the interval requested by systemd,
+// until stopWatchdog is closed. No keep-alive is sent, while alive returns
+// an error, so systemd restarts a hung receiver
+func sdWatchdogLoop(alive func(interval time.Duration) error) {
+	interval := sdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	log.Infof("systemd watchdog enabled, interval %s", interval)
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopWatchdog:
+			return
+		case <-ticker.C:
+			if err := alive(interval); err != nil {
+				log.Errorf("Skipping watchdog notification: %s", err)
+				continue
+			}
+			if err := sdNotify(sdWatchdog); err != nil {
+				log.Errorf("Error sending watchdog notification: %s", err)
+			}
+		}
+	}
+}
+
+// Returns the sockets passed by systemd socket activation. Stream sockets are
+// returned as listeners, datagram sockets as packet connections
+func sdListenFds() ([]net.Listener, []net.PacketConn, error) {
+	var (
+		listeners   []net.Listener
+		connections []net.PacketConn
+	)
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil, nil
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, nil, nil
+	}
+
+	// Don't pass the sockets on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for fd := sdListenFdsStart; fd < sdListenFdsStart+nfds; fd++ {
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+
+		sotype, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if sotype == syscall.SOCK_DGRAM {
+			conn, err := net.FilePacketConn(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			connections = append(connections, conn)
+		} else {
+			listener, err := net.FileListener(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			listeners = append(listeners, listener)
+		}
+
+		// net.File* dup the descriptor, so the original can be closed
+		file.Close()
+	}
+
+	return listeners, connections, nil
+}
@@ -0,0 +1,158 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    timezone.go
+//: details: Time zone selection for timestamps without zone information
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/hwahrmann/rsa-nw-syslog-receiver/syslog"
+)
+
+// Timezone represents a per source time zone override
+type Timezone struct {
+	Source   string
+	Host     string
+	Timezone string
+}
+
+type timezoneRule struct {
+	network  *net.IPNet
+	host     *regexp.Regexp
+	location *time.Location
+}
+
+// Timezones selects the time location by client address or extracted host
+type Timezones struct {
+	location *time.Location
+	rules    []timezoneRule
+}
+
+// NewTimezones constructs the time zone rules from the options
+func NewTimezones(opts *Options) (*Timezones, error) {
+	var err error
+
+	tz := &Timezones{}
+	tz.location, err = time.LoadLocation(opts.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid timezone %q: %s", opts.Timezone, err)
+	}
+
+	for _, t := range opts.Timezones {
+		rule := timezoneRule{}
+		rule.location, err = time.LoadLocation(t.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timezone %q: %s", t.Timezone, err)
+		}
+
+		if t.Source != "" {
+			rule.network, err = parseCIDR(t.Source)
+			if err != nil {
+				return nil, err
+			}
+		} else if t.Host != "" {
+			rule.host, err = regexp.Compile(t.Host)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("Timezone %q requires a source or host", t.Timezone)
+		}
+
+		tz.rules = append(tz.rules, rule)
+	}
+
+	return tz, nil
+}
+
+// ForClient returns the time location for the client address. The default
+// timezone is returned, when no source rule matches
+func (tz *Timezones) ForClient(client string) *time.Location {
+	ip := clientIP(client)
+	if ip != nil {
+		for _, rule := range tz.rules {
+			if rule.network != nil && rule.network.Contains(ip) {
+				return rule.location
+			}
+		}
+	}
+
+	return tz.location
+}
+
+// ForHost returns the time location for the extracted host or nil,
+// when no host rule matches
+func (tz *Timezones) ForHost(host string) *time.Location {
+	for _, rule := range tz.rules {
+		if rule.host != nil && rule.host.MatchString(host) {
+			return rule.location
+		}
+	}
+
+	return nil
+}
+
+// Returns the wall clock time of ts in the given location
+func inLocation(ts time.Time, location *time.Location) time.Time {
+	return time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(),
+		ts.Second(), ts.Nanosecond(), location)
+}
+
+// Parse a CIDR or a single IP address
+func parseCIDR(s string) (*net.IPNet, error) {
+	_, network, err := net.ParseCIDR(s)
+	if err == nil {
+		return network, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid source address %q", s)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	bits := 8 * len(ip)
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// Parse a list of CIDRs or single IP addresses
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, s := range list {
+		network, err := parseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Returns the IP address of a client in host:port notation
+func clientIP(client string) net.IP {
+	return net.ParseIP(syslog.ClientHost(client))
+}
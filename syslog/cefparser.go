@@ -0,0 +1,185 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    cefparser.go
+//: details: Parser for ArcSight Common Event Format (CEF) messages
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"strings"
+)
+
+const (
+	cefPrefix = "CEF:"
+
+	// Version|Device Vendor|Device Product|Device Version|Signature ID|Name|Severity|Extension
+	cefHeaderFields = 7
+)
+
+var (
+	ErrCEFNotFound    = &ParserError{"No CEF header found"}
+	ErrCEFHeaderShort = &ParserError{"CEF header too short"}
+)
+
+// ParseCEF parses a CEF event, which may be preceded by a syslog header
+func ParseCEF(content string) (LogParts, error) {
+	from := findFormatPrefix(content, cefPrefix)
+	if from < 0 {
+		return nil, ErrCEFNotFound
+	}
+
+	fields, extension := splitCEFHeader(content[from+len(cefPrefix):])
+	if len(fields) < cefHeaderFields {
+		return nil, ErrCEFHeaderShort
+	}
+
+	return LogParts{
+		"format":          "cef",
+		"format_version":  fields[0],
+		"vendor":          fields[1],
+		"product":         fields[2],
+		"product_version": fields[3],
+		"event_id":        fields[4],
+		"name":            fields[5],
+		"event_severity":  fields[6],
+		"extensions":      parseCEFExtension(extension),
+	}, nil
+}
+
+// Split the pipe delimited header fields. Pipes and backslashes are escaped
+// with a backslash. The remainder after the last header field is the extension
+func splitCEFHeader(s string) ([]string, string) {
+	var (
+		fields []string
+		field  strings.Builder
+	)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) && (s[i+1] == '|' || s[i+1] == '\\') {
+			i++
+			field.WriteByte(s[i])
+			continue
+		}
+
+		if c == '|' {
+			fields = append(fields, field.String())
+			field.Reset()
+			if len(fields) == cefHeaderFields {
+				return fields, s[i+1:]
+			}
+			continue
+		}
+
+		field.WriteByte(c)
+	}
+
+	// An event without extension may omit the last pipe
+	if len(fields) == cefHeaderFields-1 {
+		fields = append(fields, field.String())
+	}
+
+	return fields, ""
+}
+
+// The extension is a list of space separated key=value pairs. Values may
+// contain spaces, so a value ends where the next key starts
+func parseCEFExtension(s string) map[string]string {
+	type key struct {
+		name  string
+		start int
+		value int
+	}
+
+	var keys []key
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] != '=' {
+			continue
+		}
+
+		start := i
+		for start > 0 && s[start-1] != ' ' {
+			start--
+		}
+		if start == i {
+			continue
+		}
+		keys = append(keys, key{s[start:i], start, i + 1})
+	}
+
+	extensions := make(map[string]string, len(keys))
+	for n, k := range keys {
+		end := len(s)
+		if n+1 < len(keys) {
+			end = keys[n+1].start
+		}
+		extensions[k.name] = unescapeCEFValue(strings.TrimRight(s[k.value:end], " "))
+	}
+
+	return extensions
+}
+
+func unescapeCEFValue(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+
+	var value strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			value.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+
+	return value.String()
+}
+
+// Returns the position of a format prefix like "CEF:" at the start of the
+// content or after a space, followed by the version number. -1 if not found
+func findFormatPrefix(content string, prefix string) int {
+	offset := 0
+	for {
+		i := strings.Index(content[offset:], prefix)
+		if i < 0 {
+			return -1
+		}
+		i += offset
+
+		end := i + len(prefix)
+		if (i == 0 || content[i-1] == ' ') && end < len(content) && IsDigit(content[end]) {
+			return i
+		}
+		offset = end
+	}
+}
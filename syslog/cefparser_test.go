@@ -0,0 +1,126 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    cefparser_test.go
+//: details: Tests of the CEF parser
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCEF(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		err        error
+		header     map[string]string
+		extensions map[string]string
+	}{
+		{
+			name:    "plain",
+			content: "CEF:0|Vendor|Product|1.0|100|Login|5|src=10.0.0.1 suser=bob",
+			header: map[string]string{
+				"format_version": "0", "vendor": "Vendor", "product": "Product",
+				"product_version": "1.0", "event_id": "100", "name": "Login", "event_severity": "5",
+			},
+			extensions: map[string]string{"src": "10.0.0.1", "suser": "bob"},
+		},
+		{
+			name:       "after syslog header",
+			content:    "Oct 18 10:00:00 fw01 CEF:0|Vendor|Product|1.0|100|Login|5|src=10.0.0.1",
+			header:     map[string]string{"vendor": "Vendor", "name": "Login"},
+			extensions: map[string]string{"src": "10.0.0.1"},
+		},
+		{
+			name:       "escaped pipe and backslash in header",
+			content:    `CEF:0|Ven\|dor|Pro\\duct|1.0|100|a\|b|5|`,
+			header:     map[string]string{"vendor": "Ven|dor", "product": `Pro\duct`, "name": "a|b"},
+			extensions: map[string]string{},
+		},
+		{
+			name:       "escaped equal sign and newline in value",
+			content:    `CEF:0|V|P|1|100|N|5|msg=a\=b c\nd cs1=x`,
+			extensions: map[string]string{"msg": "a=b c\nd", "cs1": "x"},
+		},
+		{
+			name:       "escaped equal sign does not start a key",
+			content:    `CEF:0|V|P|1|100|N|5|request=http://h/?q\=1 act=allow`,
+			extensions: map[string]string{"request": "http://h/?q=1", "act": "allow"},
+		},
+		{
+			name:       "value with spaces",
+			content:    "CEF:0|V|P|1|100|N|5|msg=user logged in src=10.0.0.1",
+			extensions: map[string]string{"msg": "user logged in", "src": "10.0.0.1"},
+		},
+		{
+			name:       "without last pipe",
+			content:    "CEF:0|V|P|1|100|N|5",
+			header:     map[string]string{"event_severity": "5"},
+			extensions: map[string]string{},
+		},
+		{
+			name:    "header too short",
+			content: "CEF:0|V|P|1|100",
+			err:     ErrCEFHeaderShort,
+		},
+		{
+			name:    "escaped pipe does not end a field",
+			content: `CEF:0|V|P|1|100|N\|5`,
+			err:     ErrCEFHeaderShort,
+		},
+		{
+			name:    "no version",
+			content: "CEF:|V|P|1|100|N|5|",
+			err:     ErrCEFNotFound,
+		},
+		{
+			name:    "prefix inside a word",
+			content: "xCEF:0|V|P|1|100|N|5|",
+			err:     ErrCEFNotFound,
+		},
+		{
+			name:    "empty",
+			content: "",
+			err:     ErrCEFNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logParts, err := ParseCEF(test.content)
+			if err != test.err {
+				t.Fatalf("error %v, expected %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			for key, value := range test.header {
+				if logParts[key] != value {
+					t.Errorf("%s %q, expected %q", key, logParts[key], value)
+				}
+			}
+			if extensions := logParts["extensions"]; !reflect.DeepEqual(extensions, test.extensions) {
+				t.Errorf("extensions %q, expected %q", extensions, test.extensions)
+			}
+		})
+	}
+}
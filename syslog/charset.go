@@ -0,0 +1,191 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    charset.go
+//: details: Conversion of received messages to UTF-8
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// The byte order mark, which starts an UTF-8 encoded RFC 5424 message
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Charset is the encoding of the received messages. All supported encodings
+// keep ASCII as it is
+type Charset struct {
+	name     string
+	encoding encoding.Encoding
+}
+
+// CharsetFunc A function type which returns the charset of the messages of
+// the given client and listener. Can return nil to keep the default of UTF-8
+type CharsetFunc func(client string, listener string) *Charset
+
+// UTF8 is the default charset. Invalid sequences are replaced by U+FFFD
+var UTF8 = &Charset{name: "utf-8"}
+
+var (
+	latin1      = &Charset{name: "iso-8859-1", encoding: charmap.ISO8859_1}
+	latin2      = &Charset{name: "iso-8859-2", encoding: charmap.ISO8859_2}
+	latin9      = &Charset{name: "iso-8859-15", encoding: charmap.ISO8859_15}
+	windows1250 = &Charset{name: "windows-1250", encoding: charmap.Windows1250}
+	windows1251 = &Charset{name: "windows-1251", encoding: charmap.Windows1251}
+	windows1252 = &Charset{name: "windows-1252", encoding: charmap.Windows1252}
+	koi8r       = &Charset{name: "koi8-r", encoding: charmap.KOI8R}
+	shiftJIS    = &Charset{name: "shift_jis", encoding: japanese.ShiftJIS}
+	eucJP       = &Charset{name: "euc-jp", encoding: japanese.EUCJP}
+	eucKR       = &Charset{name: "euc-kr", encoding: korean.EUCKR}
+	gbk         = &Charset{name: "gbk", encoding: simplifiedchinese.GBK}
+	gb18030     = &Charset{name: "gb18030", encoding: simplifiedchinese.GB18030}
+	big5        = &Charset{name: "big5", encoding: traditionalchinese.Big5}
+)
+
+var charsets = map[string]*Charset{
+	"utf-8":        UTF8,
+	"utf8":         UTF8,
+	"iso-8859-1":   latin1,
+	"latin1":       latin1,
+	"iso-8859-2":   latin2,
+	"latin2":       latin2,
+	"iso-8859-15":  latin9,
+	"latin9":       latin9,
+	"windows-1250": windows1250,
+	"cp1250":       windows1250,
+	"windows-1251": windows1251,
+	"cp1251":       windows1251,
+	"windows-1252": windows1252,
+	"cp1252":       windows1252,
+	"koi8-r":       koi8r,
+	"shift_jis":    shiftJIS,
+	"shift-jis":    shiftJIS,
+	"sjis":         shiftJIS,
+	"euc-jp":       eucJP,
+	"euc-kr":       eucKR,
+	"gbk":          gbk,
+	"gb18030":      gb18030,
+	"big5":         big5,
+}
+
+// LookupCharset returns the charset of the given name
+func LookupCharset(name string) (*Charset, error) {
+	charset, ok := charsets[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported encoding %q", name)
+	}
+	return charset, nil
+}
+
+// Name returns the name of the charset
+func (c *Charset) Name() string {
+	return c.name
+}
+
+// Decode returns the line converted to UTF-8. The line is returned as it
+// is, when there is nothing to convert
+func (c *Charset) Decode(line []byte) []byte {
+	if c.encoding == nil {
+		if utf8.Valid(line) {
+			return line
+		}
+		return bytes.ToValidUTF8(line, []byte(string(utf8.RuneError)))
+	}
+
+	ascii := true
+	for _, b := range line {
+		if b >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return line
+	}
+
+	// Invalid sequences are replaced by U+FFFD
+	decoded, err := c.encoding.NewDecoder().Bytes(line)
+	if err != nil {
+		return bytes.ToValidUTF8(line, []byte(string(utf8.RuneError)))
+	}
+	return decoded
+}
+
+// Set the function that selects the charset for a client and listener
+func (s *Server) SetCharsetFunc(charsetFunc CharsetFunc) {
+	s.charsetFunc = charsetFunc
+}
+
+// Returns the line converted to UTF-8. RFC 5424 messages, whose MSG starts
+// with the BOM, are UTF-8 regardless of the charset of the client and the
+// BOM is removed
+func (s *Server) decode(line []byte, client string, listener string) []byte {
+	if i := rfc5424MsgOffset(line); i >= 0 && bytes.HasPrefix(line[i:], utf8BOM) {
+		line = append(line[:i:i], line[i+len(utf8BOM):]...)
+		return UTF8.Decode(line)
+	}
+
+	charset := UTF8
+	if s.charsetFunc != nil {
+		if c := s.charsetFunc(client, listener); c != nil {
+			charset = c
+		}
+	}
+	return charset.Decode(line)
+}
+
+// Returns the offset of the MSG of an RFC 5424 message or -1 for other
+// messages. The header is ASCII, so it is found before decoding
+func rfc5424MsgOffset(line []byte) int {
+	p := NewParser(line)
+	if _, err := p.parsePriority(); err != nil {
+		return -1
+	}
+	if p.cursor+1 >= p.l || line[p.cursor] != '1' || line[p.cursor+1] != ' ' {
+		return -1
+	}
+	p.cursor += 2
+
+	// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	for i := 0; i < 5; i++ {
+		to, err := FindNextSpace(line, p.cursor, p.l)
+		if err != nil {
+			return -1
+		}
+		p.cursor = to
+	}
+
+	if !p.skipStructuredData() {
+		return -1
+	}
+	if p.cursor < p.l && line[p.cursor] == ' ' {
+		p.cursor++
+	}
+	return p.cursor
+}
@@ -0,0 +1,342 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    gelf.go
+//: details: Graylog Extended Log Format (GELF) Listener and Parser
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// http://docs.graylog.org/en/latest/pages/gelf.html#chunking
+	gelfChunkMagic0   = 0x1e
+	gelfChunkMagic1   = 0x0f
+	gelfChunkHeader   = 12
+	gelfMaxChunks     = 128
+	gelfMaxPending    = 1024
+	gelfChunkTimeout  = 5 * time.Second
+	gelfMaxMessageLen = 8 * 1024 * 1024
+
+	// GELF level is the syslog severity. Default is 1 (alert)
+	gelfDefaultLevel = 1
+	// GELF has no facility, take user-level messages
+	gelfFacility = 1
+)
+
+var (
+	ErrGELFNoMessage = &ParserError{"GELF message without short_message"}
+	ErrGELFChunk     = &ParserError{"Invalid GELF chunk"}
+	ErrGELFPending   = &ParserError{"Too many incomplete GELF messages"}
+)
+
+// ParseGELF parses an uncompressed GELF message. Additional fields are
+// available as "json", like for JSON events
+func ParseGELF(payload []byte) (LogParts, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	shortMessage, _ := doc["short_message"].(string)
+	fullMessage, _ := doc["full_message"].(string)
+	if shortMessage == "" && fullMessage == "" {
+		return nil, ErrGELFNoMessage
+	}
+
+	content := fullMessage
+	if content == "" {
+		content = shortMessage
+	}
+
+	ts := time.Now()
+	if value, ok := doc["timestamp"].(json.Number); ok {
+		if f, err := value.Float64(); err == nil {
+			sec, frac := math.Modf(f)
+			ts = time.Unix(int64(sec), int64(frac*1e9))
+		}
+	}
+
+	level := gelfDefaultLevel
+	if value, ok := doc["level"].(json.Number); ok {
+		if l, err := value.Int64(); err == nil && l >= 0 && l <= 7 {
+			level = int(l)
+		}
+	}
+
+	host, _ := doc["host"].(string)
+	pri := newPriority(gelfFacility*8 + level)
+
+	return LogParts{
+		"timestamp":       ts,
+		"timestamp_local": false,
+		"hostname":        host,
+		"content":         content,
+		"short_message":   shortMessage,
+		"full_message":    fullMessage,
+		"priority":        pri.P,
+		"facility":        pri.F.Value,
+		"severity":        pri.S.Value,
+		"format":          "gelf",
+		"json":            doc,
+		"json_text":       string(payload),
+	}, nil
+}
+
+// Decompress a zlib or gzip compressed GELF message. Uncompressed messages
+// are returned as-is
+func gelfDecompress(payload []byte) ([]byte, error) {
+	var (
+		reader io.ReadCloser
+		err    error
+	)
+
+	switch {
+	case len(payload) > 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		reader, err = gzip.NewReader(bytes.NewReader(payload))
+	case len(payload) > 2 && payload[0] == 0x78:
+		reader, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		return payload, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(io.LimitReader(reader, gelfMaxMessageLen))
+}
+
+type gelfMessage struct {
+	chunks   [][]byte
+	received int
+	length   int
+	first    time.Time
+}
+
+// Reassembles chunked GELF messages received via UDP
+type gelfAssembler struct {
+	sync.Mutex
+	messages  map[string]*gelfMessage
+	lastSweep time.Time
+}
+
+func newGelfAssembler() *gelfAssembler {
+	return &gelfAssembler{messages: map[string]*gelfMessage{}, lastSweep: time.Now()}
+}
+
+// Add a datagram. Returns the complete message, when all chunks have been
+// received or the datagram was not chunked, otherwise nil
+func (a *gelfAssembler) add(datagram []byte) ([]byte, error) {
+	if len(datagram) < 2 || datagram[0] != gelfChunkMagic0 || datagram[1] != gelfChunkMagic1 {
+		return datagram, nil
+	}
+
+	if len(datagram) < gelfChunkHeader {
+		return nil, ErrGELFChunk
+	}
+	id := string(datagram[2:10])
+	seq := int(datagram[10])
+	count := int(datagram[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil, ErrGELFChunk
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.lastSweep) > time.Second {
+		// Drop incomplete messages
+		for key, message := range a.messages {
+			if now.Sub(message.first) > gelfChunkTimeout {
+				delete(a.messages, key)
+			}
+		}
+		a.lastSweep = now
+	}
+
+	message, ok := a.messages[id]
+	if !ok {
+		// Bound the memory used by senders never completing messages
+		if len(a.messages) >= gelfMaxPending {
+			return nil, ErrGELFPending
+		}
+		message = &gelfMessage{chunks: make([][]byte, count), first: now}
+		a.messages[id] = message
+	}
+	if len(message.chunks) != count {
+		delete(a.messages, id)
+		return nil, ErrGELFChunk
+	}
+	if message.chunks[seq] != nil {
+		return nil, nil
+	}
+
+	// The datagram buffer is reused, keep a copy
+	chunk := append([]byte(nil), datagram[gelfChunkHeader:]...)
+	message.chunks[seq] = chunk
+	message.received++
+	message.length += len(chunk)
+	if message.length > gelfMaxMessageLen {
+		delete(a.messages, id)
+		return nil, ErrGELFChunk
+	}
+	if message.received < count {
+		return nil, nil
+	}
+
+	delete(a.messages, id)
+	return bytes.Join(message.chunks, nil), nil
+}
+
+// Configure the server for listen on an UDP addr for GELF
+func (s *Server) ListenGELFUDP(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	connection, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	connection.SetReadBuffer(datagramReadBufferSize)
+
+	s.gelfConnections = append(s.gelfConnections, connection)
+	return nil
+}
+
+// Configure the server for listen on a TCP addr for null delimited GELF
+func (s *Server) ListenGELFTCP(addr string) error {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	if s.doneTcp == nil {
+		s.doneTcp = make(chan bool)
+	}
+	s.gelfListeners = append(s.gelfListeners, listener)
+	return nil
+}
+
+func (s *Server) goReceiveGELF(packetconn net.PacketConn) {
+	if s.gelfChunks == nil {
+		s.gelfChunks = newGelfAssembler()
+	}
+
+	s.wait.Add(1)
+	go func() {
+		defer s.wait.Done()
+		var listener string
+		if localAddr := packetconn.LocalAddr(); localAddr != nil {
+			listener = localAddr.String()
+		}
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := packetconn.ReadFrom(buf)
+			if err != nil {
+				// See goReceiveDatagrams
+				opError, ok := err.(*net.OpError)
+				if (ok) && !opError.Temporary() && !opError.Timeout() {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+
+			payload, err := s.gelfChunks.add(buf[:n])
+			if err != nil {
+				s.lastError = err
+				continue
+			}
+			if payload == nil {
+				continue
+			}
+
+			var address string
+			if addr != nil {
+				address = addr.String()
+			}
+			s.parseGELF(payload, address, listener, "")
+		}
+	}()
+}
+
+func (s *Server) parseGELF(payload []byte, client string, listener string, tlsPeer string) {
+	received := time.Now()
+
+	admitted, ok := s.admit(client)
+	if !ok {
+		return
+	}
+
+	payload, err := gelfDecompress(payload)
+	if err != nil {
+		s.lastError = err
+		return
+	}
+
+	logParts, err := ParseGELF(payload)
+	if err != nil {
+		s.lastError = err
+		return
+	}
+
+	for key, value := range admitted {
+		logParts[key] = value
+	}
+	s.handle(logParts, received, client, listener, tlsPeer, len(payload), nil)
+}
+
+// Split function for null delimited messages. A newline after the null byte,
+// which some senders add, is removed
+func scanNull(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, []byte(strings.TrimLeft(string(data[:i]), "\r\n")), nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
@@ -0,0 +1,318 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    gelf_test.go
+//: details: Tests of the GELF parser, decompression and chunk reassembly
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"testing"
+)
+
+// Returns a GELF chunk of the message id
+func gelfChunk(id string, seq int, count int, data string) []byte {
+	chunk := []byte{gelfChunkMagic0, gelfChunkMagic1}
+	chunk = append(chunk, fmt.Sprintf("%-8.8s", id)...)
+	chunk = append(chunk, byte(seq), byte(count))
+	return append(chunk, data...)
+}
+
+func TestGelfAssembler(t *testing.T) {
+	tests := []struct {
+		name      string
+		datagrams [][]byte
+		// The result of the last datagram
+		message string
+		err     error
+	}{
+		{
+			name:      "not chunked",
+			datagrams: [][]byte{[]byte(`{"short_message":"a"}`)},
+			message:   `{"short_message":"a"}`,
+		},
+		{
+			name:      "single chunk",
+			datagrams: [][]byte{gelfChunk("id", 0, 1, "abc")},
+			message:   "abc",
+		},
+		{
+			name:      "chunks in order",
+			datagrams: [][]byte{gelfChunk("id", 0, 2, "ab"), gelfChunk("id", 1, 2, "cd")},
+			message:   "abcd",
+		},
+		{
+			name:      "chunks out of order",
+			datagrams: [][]byte{gelfChunk("id", 2, 3, "e"), gelfChunk("id", 0, 3, "ab"), gelfChunk("id", 1, 3, "cd")},
+			message:   "abcde",
+		},
+		{
+			name:      "incomplete",
+			datagrams: [][]byte{gelfChunk("id", 0, 2, "ab")},
+		},
+		{
+			name:      "duplicate chunk",
+			datagrams: [][]byte{gelfChunk("id", 0, 2, "ab"), gelfChunk("id", 0, 2, "xx")},
+		},
+		{
+			name:      "messages are kept apart",
+			datagrams: [][]byte{gelfChunk("a", 0, 2, "a1"), gelfChunk("b", 0, 2, "b1"), gelfChunk("a", 1, 2, "a2")},
+			message:   "a1a2",
+		},
+		{
+			name:      "wrong chunk count",
+			datagrams: [][]byte{gelfChunk("id", 0, 2, "ab"), gelfChunk("id", 1, 3, "cd")},
+			err:       ErrGELFChunk,
+		},
+		{
+			name:      "sequence number not below count",
+			datagrams: [][]byte{gelfChunk("id", 2, 2, "ab")},
+			err:       ErrGELFChunk,
+		},
+		{
+			name:      "zero count",
+			datagrams: [][]byte{gelfChunk("id", 0, 0, "ab")},
+			err:       ErrGELFChunk,
+		},
+		{
+			name:      "too many chunks",
+			datagrams: [][]byte{gelfChunk("id", 0, gelfMaxChunks+1, "ab")},
+			err:       ErrGELFChunk,
+		},
+		{
+			name:      "truncated header",
+			datagrams: [][]byte{gelfChunk("id", 0, 2, "")[:gelfChunkHeader-1]},
+			err:       ErrGELFChunk,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := newGelfAssembler()
+
+			var (
+				message []byte
+				err     error
+			)
+			for _, datagram := range test.datagrams {
+				message, err = a.add(datagram)
+			}
+			if err != test.err {
+				t.Fatalf("error %v, expected %v", err, test.err)
+			}
+			if string(message) != test.message {
+				t.Errorf("message %q, expected %q", message, test.message)
+			}
+		})
+	}
+}
+
+func TestGelfAssemblerWrongCountDropsMessage(t *testing.T) {
+	a := newGelfAssembler()
+	a.add(gelfChunk("id", 0, 2, "ab"))
+	if _, err := a.add(gelfChunk("id", 1, 3, "cd")); err != ErrGELFChunk {
+		t.Fatalf("error %v, expected %v", err, ErrGELFChunk)
+	}
+
+	// The message starts over
+	a.add(gelfChunk("id", 0, 2, "ab"))
+	if message, _ := a.add(gelfChunk("id", 1, 2, "cd")); string(message) != "abcd" {
+		t.Errorf("message %q, expected %q", message, "abcd")
+	}
+}
+
+func TestGelfAssemblerMaxPending(t *testing.T) {
+	a := newGelfAssembler()
+	for i := 0; i < gelfMaxPending; i++ {
+		if _, err := a.add(gelfChunk(fmt.Sprint(i), 0, 2, "a")); err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+	}
+
+	if _, err := a.add(gelfChunk("new", 0, 2, "a")); err != ErrGELFPending {
+		t.Errorf("error %v, expected %v", err, ErrGELFPending)
+	}
+	// Pending messages are still completed
+	if message, err := a.add(gelfChunk("0", 1, 2, "b")); err != nil || string(message) != "ab" {
+		t.Errorf("message %q, error %v, expected %q", message, err, "ab")
+	}
+}
+
+func TestGelfAssemblerMaxMessageLen(t *testing.T) {
+	a := newGelfAssembler()
+	chunk := string(make([]byte, gelfMaxMessageLen/2+1))
+	a.add(gelfChunk("id", 0, 3, chunk))
+	if _, err := a.add(gelfChunk("id", 1, 3, chunk)); err != ErrGELFChunk {
+		t.Errorf("error %v, expected %v", err, ErrGELFChunk)
+	}
+}
+
+func TestGelfDecompress(t *testing.T) {
+	message := []byte(`{"short_message":"compressed"}`)
+
+	var gz, zl bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(message)
+	w.Close()
+	z := zlib.NewWriter(&zl)
+	z.Write(message)
+	z.Close()
+
+	tests := []struct {
+		name    string
+		payload []byte
+		message []byte
+		err     bool
+	}{
+		{name: "uncompressed", payload: message, message: message},
+		{name: "gzip", payload: gz.Bytes(), message: message},
+		{name: "zlib", payload: zl.Bytes(), message: message},
+		{name: "truncated gzip", payload: gz.Bytes()[:10], err: true},
+		{name: "invalid zlib header", payload: []byte{0x78, 0x00, 0x00}, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decompressed, err := gelfDecompress(test.payload)
+			if (err != nil) != test.err {
+				t.Fatalf("error %v, expected error %v", err, test.err)
+			}
+			if !test.err && !bytes.Equal(decompressed, test.message) {
+				t.Errorf("message %q, expected %q", decompressed, test.message)
+			}
+		})
+	}
+}
+
+func TestGelfDecompressLimit(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(make([]byte, gelfMaxMessageLen+1024))
+	w.Close()
+
+	decompressed, err := gelfDecompress(gz.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decompressed) != gelfMaxMessageLen {
+		t.Errorf("length %d, expected %d", len(decompressed), gelfMaxMessageLen)
+	}
+}
+
+func TestParseGELF(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		err      error
+		content  string
+		host     string
+		priority int
+	}{
+		{
+			name:     "short message",
+			payload:  `{"version":"1.1","host":"web01","short_message":"login","level":6}`,
+			content:  "login",
+			host:     "web01",
+			priority: gelfFacility*8 + 6,
+		},
+		{
+			name:     "full message is preferred",
+			payload:  `{"host":"web01","short_message":"short","full_message":"full\ntrace"}`,
+			content:  "full\ntrace",
+			host:     "web01",
+			priority: gelfFacility*8 + gelfDefaultLevel,
+		},
+		{
+			name:     "level out of range",
+			payload:  `{"short_message":"a","level":9}`,
+			content:  "a",
+			priority: gelfFacility*8 + gelfDefaultLevel,
+		},
+		{
+			name:    "without message",
+			payload: `{"host":"web01"}`,
+			err:     ErrGELFNoMessage,
+		},
+		{
+			name:    "empty messages",
+			payload: `{"short_message":"","full_message":""}`,
+			err:     ErrGELFNoMessage,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logParts, err := ParseGELF([]byte(test.payload))
+			if err != test.err {
+				t.Fatalf("error %v, expected %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if logParts["content"] != test.content {
+				t.Errorf("content %q, expected %q", logParts["content"], test.content)
+			}
+			if logParts["hostname"] != test.host {
+				t.Errorf("hostname %q, expected %q", logParts["hostname"], test.host)
+			}
+			if logParts["priority"] != test.priority {
+				t.Errorf("priority %v, expected %d", logParts["priority"], test.priority)
+			}
+		})
+	}
+}
+
+func TestParseGELFInvalid(t *testing.T) {
+	for _, payload := range []string{``, `{`, `not json`, `["short_message"]`} {
+		if _, err := ParseGELF([]byte(payload)); err == nil {
+			t.Errorf("%q: expected an error", payload)
+		}
+	}
+}
+
+func TestScanNull(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		atEOF   bool
+		advance int
+		token   string
+	}{
+		{name: "terminated", data: "{}\x00next", advance: 3, token: "{}"},
+		{name: "newline after null", data: "\n{}\x00", advance: 4, token: "{}"},
+		{name: "incomplete", data: "{}", advance: 0, token: ""},
+		{name: "incomplete at EOF", data: "{}", atEOF: true, advance: 2, token: "{}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			advance, token, err := scanNull([]byte(test.data), test.atEOF)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if advance != test.advance || string(token) != test.token {
+				t.Errorf("advance %d token %q, expected %d %q", advance, token, test.advance, test.token)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    jsonparser.go
+//: details: Parser for JSON messages, either as syslog MSG or as bare line
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrJSONNotFound = &ParserError{"No JSON object found"}
+)
+
+// JSON lines are user-level messages, notice without a level field
+const (
+	jsonFacility        = 1
+	jsonDefaultSeverity = 5
+)
+
+// The fields holding the level of a JSON line, the first found is taken
+var jsonLevelFields = []string{"severity", "level", "log.level"}
+
+// The level names of common logging libraries
+var jsonLevelNames = map[string]int{
+	"emerg": 0, "emergency": 0, "panic": 0,
+	"alert": 1,
+	"crit":  2, "critical": 2, "fatal": 2,
+	"err": 3, "error": 3,
+	"warn": 4, "warning": 4,
+	"notice": 5,
+	"info":   6, "informational": 6,
+	"debug": 7, "trace": 7,
+}
+
+// ParseJSON parses a JSON object, which may be preceded by a syslog header.
+// The decoded object is returned as "json", the object text as "json_text"
+func ParseJSON(content string) (LogParts, error) {
+	from := strings.IndexByte(content, '{')
+	if from < 0 || !strings.HasSuffix(strings.TrimRight(content, " \r\n"), "}") {
+		return nil, ErrJSONNotFound
+	}
+
+	text := strings.TrimRight(content[from:], " \r\n")
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return LogParts{
+		"format":    "json",
+		"json":      doc,
+		"json_text": text,
+	}, nil
+}
+
+// ParseJSONLine parses a bare JSON line without syslog header, e.g. newline
+// delimited JSON on TCP
+func ParseJSONLine(line []byte, received time.Time) (LogParts, error) {
+	logParts := LogParts{
+		"timestamp":       received,
+		"timestamp_local": false,
+		"hostname":        "",
+		"content":         string(bytes.TrimSpace(line)),
+	}
+
+	severity := jsonDefaultSeverity
+	parsed, err := ParseJSON(logParts["content"].(string))
+	if err == nil {
+		for key, value := range parsed {
+			logParts[key] = value
+		}
+		severity = jsonSeverity(parsed["json"].(map[string]interface{}))
+	}
+
+	// Set after the fields, which may have the same names
+	pri := newPriority(jsonFacility*8 + severity)
+	logParts["priority"] = pri.P
+	logParts["facility"] = pri.F.Value
+	logParts["severity"] = pri.S.Value
+
+	return logParts, err
+}
+
+// Returns the severity given by the level field of a JSON object as number
+// or name
+func jsonSeverity(doc map[string]interface{}) int {
+	for _, field := range jsonLevelFields {
+		value, ok := LookupJSON(doc, field)
+		if !ok {
+			continue
+		}
+
+		switch v := value.(type) {
+		case json.Number:
+			if l, err := v.Int64(); err == nil && l >= 0 && l <= 7 {
+				return int(l)
+			}
+		case string:
+			if l, ok := jsonLevelNames[strings.ToLower(v)]; ok {
+				return l
+			}
+		}
+	}
+
+	return jsonDefaultSeverity
+}
+
+// LookupJSON returns the value at a dotted path like "host.name". A key
+// containing dots, like "log.level" in flattened documents, is matched as well
+func LookupJSON(doc map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := doc[path]; ok {
+		return value, true
+	}
+
+	for i := 0; i < len(path); i++ {
+		if path[i] != '.' {
+			continue
+		}
+
+		child, ok := doc[path[:i]].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, ok := LookupJSON(child, path[i+1:]); ok {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// JSONString returns a JSON value as string. Objects and arrays are
+// returned in their JSON representation
+func JSONString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
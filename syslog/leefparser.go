@@ -0,0 +1,110 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    leefparser.go
+//: details: Parser for IBM QRadar Log Event Extended Format (LEEF) messages
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	leefPrefix = "LEEF:"
+
+	// Version|Vendor|Product|Version|EventID|Extension
+	leefHeaderFields = 5
+
+	leefDefaultDelimiter = "\t"
+)
+
+var (
+	ErrLEEFNotFound    = &ParserError{"No LEEF header found"}
+	ErrLEEFHeaderShort = &ParserError{"LEEF header too short"}
+)
+
+// ParseLEEF parses a LEEF 1.0 or 2.0 event, which may be preceded by a syslog header
+func ParseLEEF(content string) (LogParts, error) {
+	from := findFormatPrefix(content, leefPrefix)
+	if from < 0 {
+		return nil, ErrLEEFNotFound
+	}
+
+	fields := strings.SplitN(content[from+len(leefPrefix):], "|", leefHeaderFields+1)
+	if len(fields) < leefHeaderFields {
+		return nil, ErrLEEFHeaderShort
+	}
+
+	extension := ""
+	if len(fields) > leefHeaderFields {
+		extension = fields[leefHeaderFields]
+	}
+
+	// LEEF 2.0 adds the extension delimiter as an additional header field
+	delimiter := leefDefaultDelimiter
+	if strings.HasPrefix(fields[0], "2") {
+		parts := strings.SplitN(extension, "|", 2)
+		if len(parts) == 2 {
+			delimiter = parseLEEFDelimiter(parts[0])
+			extension = parts[1]
+		}
+	}
+
+	return LogParts{
+		"format":          "leef",
+		"format_version":  fields[0],
+		"vendor":          fields[1],
+		"product":         fields[2],
+		"product_version": fields[3],
+		"event_id":        fields[4],
+		"extensions":      parseLEEFExtension(extension, delimiter),
+	}, nil
+}
+
+// The delimiter is a single character or its hex value, e.g. "^", "x5E" or "0x5E"
+func parseLEEFDelimiter(s string) string {
+	lower := strings.ToLower(s)
+	for _, prefix := range []string{"0x", "x"} {
+		if len(lower) > len(prefix) && strings.HasPrefix(lower, prefix) {
+			if c, err := strconv.ParseUint(lower[len(prefix):], 16, 8); err == nil {
+				return string(rune(c))
+			}
+		}
+	}
+
+	if s == "" {
+		return leefDefaultDelimiter
+	}
+
+	return s
+}
+
+func parseLEEFExtension(s string, delimiter string) map[string]string {
+	extensions := map[string]string{}
+	for _, pair := range strings.Split(s, delimiter) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		extensions[strings.TrimSpace(kv[0])] = kv[1]
+	}
+
+	return extensions
+}
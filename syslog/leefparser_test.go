@@ -0,0 +1,104 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    leefparser_test.go
+//: details: Tests of the LEEF parser
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLEEF(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		err        error
+		header     map[string]string
+		extensions map[string]string
+	}{
+		{
+			name:    "version 1.0 with tab delimiter",
+			content: "LEEF:1.0|Vendor|Product|2.0|Login|src=10.0.0.1\tusrName=bob",
+			header: map[string]string{
+				"format_version": "1.0", "vendor": "Vendor", "product": "Product",
+				"product_version": "2.0", "event_id": "Login",
+			},
+			extensions: map[string]string{"src": "10.0.0.1", "usrName": "bob"},
+		},
+		{
+			name:       "version 2.0 with delimiter",
+			content:    "LEEF:2.0|V|P|1|E|^|src=10.0.0.1^dst=10.0.0.2",
+			extensions: map[string]string{"src": "10.0.0.1", "dst": "10.0.0.2"},
+		},
+		{
+			name:       "version 2.0 with hex delimiter",
+			content:    "LEEF:2.0|V|P|1|E|x5E|src=10.0.0.1^dst=10.0.0.2",
+			extensions: map[string]string{"src": "10.0.0.1", "dst": "10.0.0.2"},
+		},
+		{
+			name:       "value with equal sign",
+			content:    "LEEF:1.0|V|P|1|E|url=http://h/?q=1\tsev=3",
+			extensions: map[string]string{"url": "http://h/?q=1", "sev": "3"},
+		},
+		{
+			name:       "pairs without key or value are skipped",
+			content:    "LEEF:1.0|V|P|1|E|=x\tnovalue\tsrc=1",
+			extensions: map[string]string{"src": "1"},
+		},
+		{
+			name:       "without extension",
+			content:    "LEEF:1.0|V|P|1|E",
+			header:     map[string]string{"event_id": "E"},
+			extensions: map[string]string{},
+		},
+		{
+			name:    "header too short",
+			content: "LEEF:1.0|V|P|1",
+			err:     ErrLEEFHeaderShort,
+		},
+		{
+			name:    "no version",
+			content: "LEEF:|V|P|1|E|",
+			err:     ErrLEEFNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logParts, err := ParseLEEF(test.content)
+			if err != test.err {
+				t.Fatalf("error %v, expected %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			for key, value := range test.header {
+				if logParts[key] != value {
+					t.Errorf("%s %q, expected %q", key, logParts[key], value)
+				}
+			}
+			if extensions := logParts["extensions"]; !reflect.DeepEqual(extensions, test.extensions) {
+				t.Errorf("extensions %q, expected %q", extensions, test.extensions)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    multiline.go
+//: details: Reassembly of messages sent as several lines
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Interval to check for messages exceeding their timeout
+const multilineFlushInterval = 100 * time.Millisecond
+
+// Multiline describes how lines are stitched into one message. A line is a
+// continuation of the previous message of the same connection or client,
+// when it matches Continuation or does not match Start. The message is
+// complete after MaxLines lines or when no line is received for Timeout
+type Multiline struct {
+	Start        *regexp.Regexp
+	Continuation *regexp.Regexp
+	MaxLines     int
+	Timeout      time.Duration
+}
+
+// MultilineFunc A function type which returns the multiline rule for the
+// given client and listener. Can return nil for single line messages
+type MultilineFunc func(client string, listener string) *Multiline
+
+type multilineMessage struct {
+	logParts LogParts
+	received time.Time
+	client   string
+	listener string
+	tlsPeer  string
+	length   int
+	err      error
+	lines    int
+	last     time.Time
+	rule     *Multiline
+}
+
+type multilineAssembler struct {
+	sync.Mutex
+	messages map[string]*multilineMessage
+	done     chan struct{}
+}
+
+// Set the function that selects the multiline rule for a client and listener
+func (s *Server) SetMultilineFunc(multilineFunc MultilineFunc) {
+	s.multilineFunc = multilineFunc
+}
+
+// Add a parsed line. Complete messages are passed to the handler. The rule
+// is matched against the MSG part of a line, without header and tag, which
+// is also what is appended to the message
+func (s *Server) multiline(rule *Multiline, line []byte, length int, logParts LogParts, received time.Time, client string, listener string, tlsPeer string, err error) {
+	// Lines without syslog header are taken as they are
+	text, _ := logParts["msg"].(string)
+	content, _ := logParts["content"].(string)
+	if err != nil || content == "" {
+		text = string(line)
+		logParts["content"] = text
+		logParts["msg"] = text
+	} else if text == "" {
+		text = content
+	}
+
+	continuation := (rule.Continuation != nil && rule.Continuation.MatchString(text)) ||
+		(rule.Start != nil && !rule.Start.MatchString(text))
+	key := client + "|" + listener
+
+	var complete []*multilineMessage
+
+	a := s.multilineAssembler
+	a.Lock()
+	message, ok := a.messages[key]
+	if ok && continuation {
+		for _, part := range []string{"content", "message", "msg"} {
+			if value, ok := message.logParts[part].(string); ok {
+				message.logParts[part] = value + "\n" + text
+			}
+		}
+		message.length += length
+		message.lines++
+		message.last = received
+	} else {
+		if ok {
+			complete = append(complete, message)
+		}
+		message = &multilineMessage{
+			logParts: logParts,
+			received: received,
+			client:   client,
+			listener: listener,
+			tlsPeer:  tlsPeer,
+			length:   length,
+			err:      err,
+			lines:    1,
+			last:     received,
+			rule:     rule,
+		}
+		a.messages[key] = message
+	}
+	if rule.MaxLines > 0 && message.lines >= rule.MaxLines {
+		complete = append(complete, message)
+		delete(a.messages, key)
+	}
+	a.Unlock()
+
+	s.handleMultiline(complete)
+}
+
+// Pass the pending message of a closed connection to the handler
+func (s *Server) flushMultiline(client string, listener string) {
+	if s.multilineFunc == nil {
+		return
+	}
+
+	key := client + "|" + listener
+
+	a := s.multilineAssembler
+	a.Lock()
+	message, ok := a.messages[key]
+	delete(a.messages, key)
+	a.Unlock()
+
+	if ok {
+		s.handleMultiline([]*multilineMessage{message})
+	}
+}
+
+// Pass all pending messages to the handler, when the server is stopped
+func (s *Server) flushAllMultiline() {
+	var complete []*multilineMessage
+
+	a := s.multilineAssembler
+	a.Lock()
+	for key, message := range a.messages {
+		complete = append(complete, message)
+		delete(a.messages, key)
+	}
+	a.Unlock()
+
+	s.handleMultiline(complete)
+}
+
+func (s *Server) handleMultiline(messages []*multilineMessage) {
+	for _, m := range messages {
+		s.handle(m.logParts, m.received, m.client, m.listener, m.tlsPeer, m.length, m.err)
+	}
+}
+
+// Pass the messages exceeding their timeout to the handler
+func (s *Server) goFlushMultiline() {
+	a := s.multilineAssembler
+
+	s.wait.Add(1)
+	go func() {
+		defer s.wait.Done()
+
+		ticker := time.NewTicker(multilineFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.done:
+				return
+			case now := <-ticker.C:
+				var complete []*multilineMessage
+
+				a.Lock()
+				for key, message := range a.messages {
+					if now.Sub(message.last) >= message.rule.Timeout {
+						complete = append(complete, message)
+						delete(a.messages, key)
+					}
+				}
+				a.Unlock()
+
+				s.handleMultiline(complete)
+			}
+		}
+	}()
+}
@@ -0,0 +1,235 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    proxyproto.go
+//: details: PROXY protocol v1 and v2 support for TCP listeners
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+const (
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107
+	proxyHeaderWait  = 5 * time.Second
+
+	proxyV2CmdLocal   = 0x0
+	proxyV2CmdProxy   = 0x1
+	proxyV2FamilyInet = 0x1
+	proxyV2FamilyIPv6 = 0x2
+)
+
+var (
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrProxyHeader = &ParserError{"Invalid PROXY protocol header"}
+)
+
+// ProxyListener reads the PROXY protocol header of connections accepted from
+// trusted proxies and reports the source address it carries as remote address
+type ProxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+// NewProxyListener wraps a listener. Without trusted networks, no remote
+// address is trusted
+func NewProxyListener(listener net.Listener, trusted []*net.IPNet) *ProxyListener {
+	return &ProxyListener{Listener: listener, trusted: trusted}
+}
+
+// Accept waits for a connection. The PROXY protocol header of a connection
+// from a trusted proxy is read on its first use, so a slow client does not
+// block accepting others
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (l *ProxyListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range l.trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+	once   sync.Once
+	err    error
+}
+
+// Read fails, when the PROXY protocol header is invalid
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if err := c.header(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the source address sent by the proxy
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.header() == nil && c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// Read the PROXY protocol header once
+func (c *proxyConn) header() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderWait))
+		c.err = c.readHeader()
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+	return c.err
+}
+
+func (c *proxyConn) readHeader() error {
+	signature, err := c.reader.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(signature, proxyV2Signature) {
+		return c.readHeaderV2()
+	}
+
+	prefix, err := c.reader.Peek(len(proxyV1Prefix))
+	if err != nil || string(prefix) != proxyV1Prefix {
+		return ErrProxyHeader
+	}
+
+	return c.readHeaderV1()
+}
+
+// PROXY TCP4 192.0.2.1 192.0.2.2 56324 514\r\n
+func (c *proxyConn) readHeaderV1() error {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := c.reader.ReadByte()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return ErrProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return ErrProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return ErrProxyHeader
+	}
+
+	c.remote = &net.TCPAddr{IP: ip, Port: port}
+	return nil
+}
+
+func (c *proxyConn) readHeaderV2() error {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+
+	verCmd := header[12]
+	family := header[13] >> 4
+	length := binary.BigEndian.Uint16(header[14:16])
+	if verCmd>>4 != 2 {
+		return ErrProxyHeader
+	}
+
+	addresses := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, addresses); err != nil {
+		return err
+	}
+
+	// Health checks of the proxy itself
+	if verCmd&0x0f == proxyV2CmdLocal {
+		return nil
+	}
+	if verCmd&0x0f != proxyV2CmdProxy {
+		return ErrProxyHeader
+	}
+
+	switch family {
+	case proxyV2FamilyInet:
+		if length < 12 {
+			return ErrProxyHeader
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(addresses[0:4]), Port: int(binary.BigEndian.Uint16(addresses[8:10]))}
+	case proxyV2FamilyIPv6:
+		if length < 36 {
+			return ErrProxyHeader
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(addresses[0:16]), Port: int(binary.BigEndian.Uint16(addresses[32:34]))}
+	}
+
+	return nil
+}
+
+// Configure the server for listen on a TCP addr behind a proxy sending the
+// PROXY protocol header
+func (s *Server) ListenTCPProxy(addr string, trusted []*net.IPNet) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.AddListener(NewProxyListener(listener, trusted))
+	return nil
+}
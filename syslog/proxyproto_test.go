@@ -0,0 +1,169 @@
+//: ----------------------------------------------------------------------------
+//: Copyright (C) 2019 Helmut Wahrmann.
+//:
+//: file:    proxyproto_test.go
+//: details: Tests of the PROXY protocol v1 and v2 header parsing
+//: author:  Helmut Wahrmann
+//: date:    18/10/2026
+//:
+//: Licensed under the Apache License, Version 2.0 (the "License");
+//: you may not use this file except in compliance with the License.
+//: You may obtain a copy of the License at
+//:
+//:     http://www.apache.org/licenses/LICENSE-2.0
+//:
+//: Unless required by applicable law or agreed to in writing, software
+//: distributed under the License is distributed on an "AS IS" BASIS,
+//: WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//: See the License for the specific language governing permissions and
+//: limitations under the License.
+//: ----------------------------------------------------------------------------
+
+package syslog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Returns a PROXY protocol v2 header
+func proxyV2Header(verCmd byte, family byte, addresses []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, verCmd, family<<4|0x1, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+// Returns the source and destination addresses and ports of a v2 header
+func proxyV2Addresses(src string, dst string, srcPort uint16, dstPort uint16) []byte {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	if ip4 := srcIP.To4(); ip4 != nil {
+		srcIP, dstIP = ip4, dstIP.To4()
+	}
+
+	addresses := append(append([]byte(nil), srcIP...), dstIP...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], srcPort)
+	binary.BigEndian.PutUint16(ports[2:4], dstPort)
+	return append(addresses, ports...)
+}
+
+func TestProxyConn(t *testing.T) {
+	const payload = "<13>Oct 18 10:00:00 fw01 sshd: login\n"
+
+	inet := proxyV2Addresses("192.0.2.1", "192.0.2.2", 56324, 514)
+	inet6 := proxyV2Addresses("2001:db8::1", "2001:db8::2", 56324, 514)
+
+	tests := []struct {
+		name   string
+		header []byte
+		// The remote address, "pipe" when the address of the connection is kept
+		remote string
+		err    bool
+	}{
+		{name: "v1 TCP4", header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 514\r\n"), remote: "192.0.2.1:56324"},
+		{name: "v1 TCP6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 514\r\n"), remote: "[2001:db8::1]:56324"},
+		{name: "v1 UNKNOWN", header: []byte("PROXY UNKNOWN\r\n"), remote: "pipe"},
+		{name: "v1 without CR", header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 514\n"), err: true},
+		{name: "v1 too long", header: []byte("PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLength) + "\r\n"), err: true},
+		{name: "v1 missing port", header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324\r\n"), err: true},
+		{name: "v1 unknown protocol", header: []byte("PROXY UDP4 192.0.2.1 192.0.2.2 56324 514\r\n"), err: true},
+		{name: "v1 invalid address", header: []byte("PROXY TCP4 192.0.2.256 192.0.2.2 56324 514\r\n"), err: true},
+		{name: "v1 invalid port", header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 70000 514\r\n"), err: true},
+		{name: "v1 truncated", header: []byte("PROXY TCP4 192.0.2.1"), err: true},
+		{name: "no header", header: nil, err: true},
+		{name: "v2 TCP4", header: proxyV2Header(0x21, proxyV2FamilyInet, inet), remote: "192.0.2.1:56324"},
+		{name: "v2 TCP6", header: proxyV2Header(0x21, proxyV2FamilyIPv6, inet6), remote: "[2001:db8::1]:56324"},
+		{name: "v2 with TLVs", header: proxyV2Header(0x21, proxyV2FamilyInet, append(inet, 0x04, 0x00, 0x01, 0x00)), remote: "192.0.2.1:56324"},
+		{name: "v2 LOCAL", header: proxyV2Header(0x20, 0, nil), remote: "pipe"},
+		{name: "v2 unspecified family", header: proxyV2Header(0x21, 0, nil), remote: "pipe"},
+		{name: "v2 wrong version", header: proxyV2Header(0x11, proxyV2FamilyInet, inet), err: true},
+		{name: "v2 unknown command", header: proxyV2Header(0x22, proxyV2FamilyInet, inet), err: true},
+		{name: "v2 TCP4 addresses too short", header: proxyV2Header(0x21, proxyV2FamilyInet, inet[:8]), err: true},
+		{name: "v2 TCP6 addresses too short", header: proxyV2Header(0x21, proxyV2FamilyIPv6, inet), err: true},
+		{name: "v2 truncated header", header: proxyV2Header(0x21, proxyV2FamilyInet, inet)[:len(proxyV2Signature)+2], err: true},
+		{name: "v2 truncated addresses", header: proxyV2Header(0x21, proxyV2FamilyInet, inet)[:len(proxyV2Signature)+4+6], err: true},
+		{name: "v2 truncated signature", header: proxyV2Signature[:6], err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+
+			go func() {
+				client.Write(test.header)
+				// Truncated headers end with the connection
+				if !test.err {
+					client.Write([]byte(payload))
+				}
+				client.Close()
+			}()
+
+			conn := &proxyConn{Conn: server, reader: bufio.NewReader(server)}
+			data, err := ioutil.ReadAll(conn)
+			if (err != nil) != test.err {
+				t.Fatalf("error %v, expected error %v", err, test.err)
+			}
+			if test.err {
+				if remote := conn.RemoteAddr().String(); remote != "pipe" {
+					t.Errorf("remote address %s of an invalid header, expected pipe", remote)
+				}
+				return
+			}
+
+			if string(data) != payload {
+				t.Errorf("payload %q, expected %q", data, payload)
+			}
+			if remote := conn.RemoteAddr().String(); remote != test.remote {
+				t.Errorf("remote address %s, expected %s", remote, test.remote)
+			}
+		})
+	}
+}
+
+func TestProxyListenerTrusted(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, other, _ := net.ParseCIDR("192.0.2.0/24")
+
+	tests := []struct {
+		name    string
+		trusted []*net.IPNet
+		proxied bool
+	}{
+		{name: "trusted", trusted: []*net.IPNet{loopback}, proxied: true},
+		{name: "not trusted", trusted: []*net.IPNet{other}},
+		{name: "nobody trusted", trusted: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Skip(err)
+			}
+			proxyListener := NewProxyListener(listener, test.trusted)
+			defer proxyListener.Close()
+
+			client, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			conn, err := proxyListener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if _, proxied := conn.(*proxyConn); proxied != test.proxied {
+				t.Errorf("PROXY header read %v, expected %v", proxied, test.proxied)
+			}
+		})
+	}
+}
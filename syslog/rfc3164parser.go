@@ -26,6 +26,7 @@ package syslog
 
 import (
 	"bytes"
+	"regexp"
 	"strconv"
 	"time"
 )
@@ -36,36 +37,64 @@ type Parser struct {
 	cursor         int
 	l              int
 	priority       Priority
+	hasPriority    bool
 	version        int
 	header         header
 	message        string
 	location       *time.Location
+	localTime      bool
+	unixTimeUnit   time.Duration
 	envisionFormat bool
+	rfc5424        bool
+	tagCursor      int
+	headerCursor   int
+	msg            string
+	tag            string
+	appName        string
+	pid            string
 }
 
+// Cisco style tags, e.g. "%ASA-6-302013:" or "%LINEPROTO-5-UPDOWN:". They
+// may follow an empty hostname, a sequence number and a timestamp of the
+// device, e.g. "123: *Mar  1 18:46:11.123 UTC: %SYS-5-CONFIG_I:"
+var ciscoTag = regexp.MustCompile(`^\s*(?::\s*)?(?:\d+:\s*)?` +
+	`(?:[*.]?[A-Z][a-z]{2}\s+\d{1,2}\s+(?:\d{4}\s+)?\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:\s+[A-Z]{2,5})?:\s*)?` +
+	`(%([A-Z0-9_]+)-[0-7]-[A-Z0-9_]+):`)
+
 type header struct {
 	timestamp time.Time
 	hostname  string
+	// The timestamp is a wall clock time without zone information
+	local bool
 }
 
 // NewParser returns a new Parser instance
 func NewParser(buff []byte) *Parser {
 	return &Parser{
-		buff:     buff,
-		cursor:   0,
-		l:        len(buff),
-		location: time.UTC,
+		buff:         buff,
+		cursor:       0,
+		l:            len(buff),
+		location:     time.UTC,
+		unixTimeUnit: time.Second,
+		tagCursor:    -1,
 	}
 }
 
-// Location returns a new Tiome location
+// Location sets the time location used for timestamps without zone information
 func (p *Parser) Location(location *time.Location) {
 	p.location = location
 }
 
+// UnixTimeUnit sets the unit of the Unix time in enVision headers. The
+// default is time.Second
+func (p *Parser) UnixTimeUnit(unit time.Duration) {
+	p.unixTimeUnit = unit
+}
+
 // Parse invokes parsing of the received syslog message
 func (p *Parser) Parse() error {
 	pri, err := p.parsePriority()
+	p.hasPriority = err == nil
 	if err != nil {
 		if err == ErrEnvisionFormat {
 			p.cursor = 0
@@ -76,6 +105,7 @@ func (p *Parser) Parse() error {
 	}
 
 	tcursor := p.cursor
+	p.headerCursor = tcursor
 	hdr, err := p.parseHeader()
 	if err == ErrTimestampUnknownFormat {
 		// RFC3164 sec 4.3.2.
@@ -99,20 +129,59 @@ func (p *Parser) Parse() error {
 	p.version = NO_VERSION
 	p.header = hdr
 	p.message = msg
+	p.parseTagFields()
 
 	return nil
 }
 
-// Dump dumps the parsed message into LogParts struct
+// Dump dumps the parsed message into LogParts struct. The priority,
+// facility and severity are left out, when the message has no PRI
 func (p *Parser) Dump() LogParts {
-	return LogParts{
-		"timestamp": p.header.timestamp,
-		"hostname":  p.header.hostname,
-		"content":   p.message,
-		"priority":  p.priority.P,
-		"facility":  p.priority.F.Value,
-		"severity":  p.priority.S.Value,
+	logParts := LogParts{
+		"timestamp":       p.header.timestamp,
+		"timestamp_local": p.header.local,
+		"hostname":        p.header.hostname,
+		"content":         p.message,
+		"message":         p.msgPart(),
+		"msg":             p.msgOnly(),
+		"tag":             p.tag,
+		"app_name":        p.appName,
+		"pid":             p.pid,
+	}
+	if p.hasPriority {
+		logParts["priority"] = p.priority.P
+		logParts["facility"] = p.priority.F.Value
+		logParts["severity"] = p.priority.S.Value
+	}
+	return logParts
+}
+
+// The MSG part without header. For RFC3164 it is the text after the
+// hostname, including the tag
+func (p *Parser) msgPart() string {
+	if p.rfc5424 {
+		if p.msg != "" {
+			return p.msg
+		}
+		return p.message
+	}
+	if p.tagCursor >= 0 && p.tagCursor <= p.l && !p.envisionFormat {
+		return string(p.buff[p.tagCursor:p.l])
+	}
+	return p.message
+}
+
+// The MSG part without header and tag, e.g. to compare messages or to
+// join the lines of a multiline message. Leading blanks of the MSG are kept,
+// as they indent e.g. the lines of a stack trace
+func (p *Parser) msgOnly() string {
+	if p.msg != "" {
+		return p.msg
+	}
+	if p.tagCursor >= 0 && p.tagCursor <= p.l && !p.envisionFormat {
+		return string(bytes.TrimRight(p.buff[p.tagCursor:p.l], " "))
 	}
+	return p.message
 }
 
 func (p *Parser) parsePriority() (Priority, error) {
@@ -130,17 +199,27 @@ func (p *Parser) parseHeader() (header, error) {
 		ts = time.Now()
 	}
 
-	if string(p.buff[p.cursor:p.cursor+4]) == "[][]" {
+	if bytes.HasPrefix(p.buff[p.cursor:], []byte("[][]")) {
 		p.envisionFormat = true
 		p.cursor = tcursor
 	}
-	hostname, err := p.parseHostname()
-	if err != nil {
-		return hdr, err
+
+	// The hostname follows the timestamp
+	var hostname string
+	if p.envisionFormat || err == nil {
+		hostname, err = p.parseHostname()
+		if err != nil {
+			return hdr, err
+		}
+		if !p.envisionFormat {
+			// The tag follows the hostname
+			p.tagCursor = p.cursor
+		}
 	}
 
 	hdr.timestamp = ts
 	hdr.hostname = hostname
+	hdr.local = p.localTime
 
 	return hdr, nil
 }
@@ -185,6 +264,10 @@ func (p *Parser) parseTimestamp() (time.Time, error) {
 	}
 
 	if !found {
+		if ts, ok := p.parseRFC5424Timestamp(); ok {
+			return ts, nil
+		}
+
 		// Handle the envision Header time
 		p.cursor = tcursor
 		if p.envisionFormat || string(p.buff[p.cursor:p.cursor+1]) == "[" {
@@ -193,11 +276,14 @@ func (p *Parser) parseTimestamp() (time.Time, error) {
 			tmpBuf = tmpBuf[openBracket:]
 			closingBracket := bytes.Index(tmpBuf, []byte("]"))
 			unxTime, _ := strconv.ParseInt(string(tmpBuf[1:closingBracket]), 10, 64)
-			ts = time.Unix(unxTime/1000, 0)
+			ts = UnixTime(unxTime, p.unixTimeUnit)
 			return ts, nil
 		}
 
 		p.cursor = tsFmtLen
+		if p.cursor > p.l {
+			p.cursor = p.l
+		}
 		// XXX : If the timestamp is invalid we try to push the cursor one byte
 		// XXX : further, in case it is a space
 		if (p.cursor < p.l) && (p.buff[p.cursor] == ' ') {
@@ -208,6 +294,7 @@ func (p *Parser) parseTimestamp() (time.Time, error) {
 	}
 
 	fixTimestampIfNeeded(&ts)
+	p.localTime = true
 
 	p.cursor += tsFmtLen
 
@@ -218,56 +305,209 @@ func (p *Parser) parseTimestamp() (time.Time, error) {
 	return ts, nil
 }
 
+// https://tools.ietf.org/html/rfc5424#section-6.2.3
+// The timestamp follows the version and keeps its fractional seconds
+func (p *Parser) parseRFC5424Timestamp() (time.Time, bool) {
+	cursor := p.cursor
+	if cursor >= p.l || !IsDigit(p.buff[cursor]) {
+		return time.Time{}, false
+	}
+
+	from, err := FindNextSpace(p.buff, cursor, p.l)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, c := range p.buff[cursor : from-1] {
+		if !IsDigit(c) {
+			return time.Time{}, false
+		}
+	}
+
+	to, err := FindNextSpace(p.buff, from, p.l)
+	end := to - 1
+	if err != nil {
+		to = p.l
+		end = p.l
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, string(p.buff[from:end]))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	p.cursor = to
+	p.rfc5424 = true
+	return ts, true
+}
+
 func (p *Parser) parseHostname() (string, error) {
 	return ParseHostname(p.buff, &p.cursor, p.l)
 }
 
 // http://tools.ietf.org/html/rfc3164#section-4.1.3
-func (p *Parser) parseTag() (string, error) {
-	var b byte
-	var endOfTag bool
-	var bracketOpen bool
-	var tag []byte
-	var err error
-	var found bool
+// The TAG is the name of the program, optionally followed by the PID in
+// brackets, and terminated by a colon, e.g. "sshd[1234]:"
+func (p *Parser) parseTag() (string, string, error) {
+	var tag, pid []byte
 
 	from := p.cursor
 
-	for {
-		if p.cursor == p.l {
-			// no tag found, reset cursor for content
-			p.cursor = from
-			return "", nil
-		}
+	for p.cursor < p.l {
+		b := p.buff[p.cursor]
 
-		b = p.buff[p.cursor]
-		bracketOpen = (b == '[')
-		endOfTag = (b == ':' || b == ' ')
+		if b == ':' {
+			if tag == nil {
+				tag = p.buff[from:p.cursor]
+			}
+			if len(tag) == 0 {
+				break
+			}
 
-		// XXX : parse PID ?
-		if bracketOpen {
-			tag = p.buff[from:p.cursor]
-			found = true
+			p.cursor++
+			if (p.cursor < p.l) && (p.buff[p.cursor] == ' ') {
+				p.cursor++
+			}
+			return string(tag), string(pid), nil
 		}
 
-		if endOfTag {
-			if !found {
-				tag = p.buff[from:p.cursor]
-				found = true
+		if b == '[' && tag == nil {
+			end := bytes.IndexByte(p.buff[p.cursor:p.l], ']')
+			if end < 0 {
+				break
+			}
+			tag = p.buff[from:p.cursor]
+			pid = p.buff[p.cursor+1 : p.cursor+end]
+			p.cursor += end + 1
+			// The PID has to be followed by the colon
+			if (p.cursor < p.l) && (p.buff[p.cursor] != ':') {
+				break
 			}
+			continue
+		}
 
-			p.cursor++
+		if tag != nil || !isTagChar(b) {
 			break
 		}
 
 		p.cursor++
 	}
 
-	if (p.cursor < p.l) && (p.buff[p.cursor] == ' ') {
+	// no tag found, reset cursor for content
+	p.cursor = from
+	return "", "", ErrTagNotFound
+}
+
+// Extract the TAG and PID of the message. Cisco style %FAC-SEV-MNEMONIC tags
+// are matched at the tag position after the hostname or, for devices sending
+// no hostname or a timestamp of their own, right after the PRI
+func (p *Parser) parseTagFields() {
+	for _, cursor := range []int{p.tagCursor, p.headerCursor} {
+		if cursor < 0 || p.envisionFormat {
+			continue
+		}
+		if m := ciscoTag.FindSubmatch(p.buff[cursor:p.l]); m != nil {
+			p.tag = string(m[1])
+			p.appName = string(m[2])
+			p.msg = string(bytes.TrimRight(bytes.TrimPrefix(p.buff[cursor+len(m[0]):p.l], []byte(" ")), " "))
+			return
+		}
+	}
+
+	if p.tagCursor < 0 {
+		return
+	}
+
+	cursor := p.cursor
+	defer func() { p.cursor = cursor }()
+	p.cursor = p.tagCursor
+
+	if p.rfc5424 {
+		p.parseRFC5424Fields()
+		return
+	}
+
+	// A CEF or LEEF payload is no tag
+	rest := string(p.buff[p.tagCursor:p.l])
+	if findFormatPrefix(rest, cefPrefix) == 0 || findFormatPrefix(rest, leefPrefix) == 0 {
+		return
+	}
+
+	tag, pid, err := p.parseTag()
+	if err == nil {
+		p.tag = tag
+		p.appName = tag
+		p.pid = pid
+		p.msg = string(bytes.TrimRight(p.buff[p.cursor:p.l], " "))
+	}
+}
+
+// https://tools.ietf.org/html/rfc5424#section-6.2
+// APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA follow the hostname
+func (p *Parser) parseRFC5424Fields() {
+	var fields []string
+
+	for len(fields) < 3 {
+		to, err := FindNextSpace(p.buff, p.cursor, p.l)
+		if err != nil {
+			return
+		}
+
+		field := string(p.buff[p.cursor : to-1])
+		if field == "-" {
+			field = ""
+		}
+		fields = append(fields, field)
+		p.cursor = to
+	}
+
+	p.tag = fields[0]
+	p.appName = fields[0]
+	p.pid = fields[1]
+
+	if p.skipStructuredData() {
+		if (p.cursor < p.l) && (p.buff[p.cursor] == ' ') {
+			p.cursor++
+		}
+		p.msg = string(bytes.Trim(p.buff[p.cursor:p.l], " "))
+	}
+}
+
+// https://tools.ietf.org/html/rfc5424#section-6.3
+// Moves the cursor behind the structured data. Returns false, if it is invalid
+func (p *Parser) skipStructuredData() bool {
+	if p.cursor >= p.l {
+		return false
+	}
+
+	if p.buff[p.cursor] == '-' {
 		p.cursor++
+		return true
 	}
 
-	return string(tag), err
+	for p.cursor < p.l && p.buff[p.cursor] == '[' {
+		inQuotes := false
+		for p.cursor++; p.cursor < p.l; p.cursor++ {
+			c := p.buff[p.cursor]
+			if c == '\\' && inQuotes {
+				p.cursor++
+			} else if c == '"' {
+				inQuotes = !inQuotes
+			} else if c == ']' && !inQuotes {
+				break
+			}
+		}
+		if p.cursor >= p.l {
+			return false
+		}
+		p.cursor++
+	}
+
+	return true
+}
+
+func isTagChar(c byte) bool {
+	return IsDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		c == '-' || c == '_' || c == '.' || c == '/'
 }
 
 func (p *Parser) parseContent() (string, error) {
@@ -282,15 +522,5 @@ func (p *Parser) parseContent() (string, error) {
 }
 
 func fixTimestampIfNeeded(ts *time.Time) {
-	now := time.Now()
-	y := ts.Year()
-
-	if ts.Year() == 0 {
-		y = now.Year()
-	}
-
-	newTs := time.Date(y, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(),
-		ts.Second(), ts.Nanosecond(), ts.Location())
-
-	*ts = newTs
+	*ts = InferYear(*ts)
 }
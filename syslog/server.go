@@ -29,7 +29,6 @@ import (
 	"crypto/tls"
 	"errors"
 	"net"
-	"strings"
 	"sync"
 	"time"
 )
@@ -39,13 +38,39 @@ const (
 	datagramReadBufferSize    = 64 * 1024
 )
 
+// Format of the messages received by the server
+type Format int
+
+const (
+	// FormatSyslog are syslog messages, which may contain CEF, LEEF or JSON
+	FormatSyslog Format = iota
+	// FormatJSON are bare JSON objects, one per line or datagram
+	FormatJSON
+)
+
 // TLSPeerNameFunc A function type which gets the TLS peer name from the connection. Can return
 // ok=false to terminate the connection
 type TLSPeerNameFunc func(tlsConn *tls.Conn) (tlsPeer string, ok bool)
 
+// LocationFunc A function type which returns the time location for timestamps
+// without zone information received from the given client. Can return nil to
+// keep the default of UTC
+type LocationFunc func(client string) *time.Location
+
+// AdmitFunc A function type which decides, whether a message received from
+// the given client is parsed, e.g. to apply rate limits. The returned log
+// parts are added to the parsed message
+type AdmitFunc func(client string) (LogParts, bool)
+
+// A function type which parses a received message and passes it to the handler
+type parseFunc func(line []byte, client string, listener string, tlsPeer string)
+
 type Server struct {
 	listeners               []net.Listener
 	connections             []net.PacketConn
+	gelfListeners           []net.Listener
+	gelfConnections         []net.PacketConn
+	gelfChunks              *gelfAssembler
 	wait                    sync.WaitGroup
 	doneTcp                 chan bool
 	datagramChannel         chan DatagramMessage
@@ -53,6 +78,13 @@ type Server struct {
 	lastError               error
 	readTimeoutMilliseconds int64
 	tlsPeerNameFunc         TLSPeerNameFunc
+	locationFunc            LocationFunc
+	admitFunc               AdmitFunc
+	unixTimeUnit            time.Duration
+	format                  Format
+	multilineFunc           MultilineFunc
+	charsetFunc             CharsetFunc
+	multilineAssembler      *multilineAssembler
 	datagramPool            sync.Pool
 }
 
@@ -83,6 +115,26 @@ func (s *Server) SetTlsPeerNameFunc(tlsPeerNameFunc TLSPeerNameFunc) {
 	s.tlsPeerNameFunc = tlsPeerNameFunc
 }
 
+// Set the function that selects the time location for a client
+func (s *Server) SetLocationFunc(locationFunc LocationFunc) {
+	s.locationFunc = locationFunc
+}
+
+// Set the function that decides, whether a message of a client is parsed
+func (s *Server) SetAdmitFunc(admitFunc AdmitFunc) {
+	s.admitFunc = admitFunc
+}
+
+// Set the format of the received messages
+func (s *Server) SetFormat(format Format) {
+	s.format = format
+}
+
+// Set the unit of the Unix time in enVision headers, e.g. time.Second
+func (s *Server) SetUnixTimeUnit(unit time.Duration) {
+	s.unixTimeUnit = unit
+}
+
 // Default TLS peer name function - returns the CN of the certificate
 func defaultTlsPeerName(tlsConn *tls.Conn) (tlsPeer string, ok bool) {
 	state := tlsConn.ConnectionState()
@@ -157,6 +209,21 @@ func (s *Server) ListenTCPTLS(addr string, config *tls.Config) error {
 	return nil
 }
 
+//Configure the server to use an already opened listener, e.g. one passed
+//in by systemd socket activation
+func (s *Server) AddListener(listener net.Listener) {
+	if s.doneTcp == nil {
+		s.doneTcp = make(chan bool)
+	}
+	s.listeners = append(s.listeners, listener)
+}
+
+//Configure the server to use an already opened packet connection, e.g. one
+//passed in by systemd socket activation
+func (s *Server) AddPacketConn(connection net.PacketConn) {
+	s.connections = append(s.connections, connection)
+}
+
 //Starts the server, all the go routines goes to live
 func (s *Server) Boot() error {
 
@@ -164,8 +231,21 @@ func (s *Server) Boot() error {
 		return errors.New("please set a valid handler")
 	}
 
+	if s.multilineFunc != nil {
+		s.multilineAssembler = &multilineAssembler{messages: map[string]*multilineMessage{}, done: make(chan struct{})}
+		s.goFlushMultiline()
+	}
+
 	for _, listener := range s.listeners {
-		s.goAcceptConnection(listener)
+		s.goAcceptConnection(listener, bufio.ScanLines, bufio.MaxScanTokenSize, s.parser)
+	}
+
+	for _, listener := range s.gelfListeners {
+		s.goAcceptConnection(listener, scanNull, gelfMaxMessageLen, s.parseGELF)
+	}
+
+	for _, connection := range s.gelfConnections {
+		s.goReceiveGELF(connection)
 	}
 
 	if len(s.connections) > 0 {
@@ -179,7 +259,7 @@ func (s *Server) Boot() error {
 	return nil
 }
 
-func (s *Server) goAcceptConnection(listener net.Listener) {
+func (s *Server) goAcceptConnection(listener net.Listener, split bufio.SplitFunc, maxLen int, parse parseFunc) {
 	s.wait.Add(1)
 	go func(listener net.Listener) {
 	loop:
@@ -194,21 +274,34 @@ func (s *Server) goAcceptConnection(listener net.Listener) {
 				continue
 			}
 
-			s.goScanConnection(connection)
+			// Scan in a goroutine, as the TLS handshake and the PROXY
+			// protocol header of a slow client would block the others
+			s.wait.Add(1)
+			go func() {
+				defer s.wait.Done()
+				s.goScanConnection(connection, split, maxLen, parse)
+			}()
 		}
 
 		s.wait.Done()
 	}(listener)
 }
 
-func (s *Server) goScanConnection(connection net.Conn) {
+// Messages longer than maxLen terminate the connection
+func (s *Server) goScanConnection(connection net.Conn, split bufio.SplitFunc, maxLen int, parse parseFunc) {
 	scanner := bufio.NewScanner(connection)
+	scanner.Buffer(make([]byte, 4096), maxLen)
+	scanner.Split(split)
 
 	remoteAddr := connection.RemoteAddr()
 	var client string
 	if remoteAddr != nil {
 		client = remoteAddr.String()
 	}
+	var listener string
+	if localAddr := connection.LocalAddr(); localAddr != nil {
+		listener = localAddr.String()
+	}
 
 	tlsPeer := ""
 	if tlsConn, ok := connection.(*tls.Conn); ok {
@@ -231,10 +324,10 @@ func (s *Server) goScanConnection(connection net.Conn) {
 	scanCloser = &ScanCloser{scanner, connection}
 
 	s.wait.Add(1)
-	go s.scan(scanCloser, client, tlsPeer)
+	go s.scan(scanCloser, client, listener, tlsPeer, parse)
 }
 
-func (s *Server) scan(scanCloser *ScanCloser, client string, tlsPeer string) {
+func (s *Server) scan(scanCloser *ScanCloser, client string, listener string, tlsPeer string, parse parseFunc) {
 loop:
 	for {
 		select {
@@ -246,35 +339,117 @@ loop:
 			scanCloser.closer.SetReadDeadline(time.Now().Add(time.Duration(s.readTimeoutMilliseconds) * time.Millisecond))
 		}
 		if scanCloser.Scan() {
-			s.parser([]byte(scanCloser.Text()), client, tlsPeer)
+			parse([]byte(scanCloser.Text()), client, listener, tlsPeer)
 		} else {
 			break loop
 		}
 	}
 	scanCloser.closer.Close()
+	s.flushMultiline(client, listener)
 
 	s.wait.Done()
 }
 
-func (s *Server) parser(line []byte, client string, tlsPeer string) {
-	parser := NewParser(line)
-	err := parser.Parse()
+func (s *Server) parser(line []byte, client string, listener string, tlsPeer string) {
+	received := time.Now()
+	length := len(line)
+	admitted, ok := s.admit(client)
+	if !ok {
+		return
+	}
+	line = s.decode(line, client, listener)
+	logParts, err := s.parseLine(line, client, received)
+	for key, value := range admitted {
+		logParts[key] = value
+	}
+	if s.multilineFunc != nil {
+		if rule := s.multilineFunc(client, listener); rule != nil {
+			s.multiline(rule, line, length, logParts, received, client, listener, tlsPeer, err)
+			return
+		}
+	}
+	s.handle(logParts, received, client, listener, tlsPeer, length, err)
+}
+
+// Returns the log parts to add and false, when the message of a client is
+// not to be parsed
+func (s *Server) admit(client string) (LogParts, bool) {
+	if s.admitFunc == nil {
+		return nil, true
+	}
+	return s.admitFunc(client)
+}
+
+// Parse parses a message as if received from client and returns it instead
+// of passing it to the handler, e.g. to test a configuration
+func (s *Server) Parse(line []byte, client string) (LogParts, error) {
+	received := time.Now()
+	line = s.decode(line, client, "")
+	logParts, err := s.parseLine(line, client, received)
+	addDetails(logParts, received, client, "", "")
+	return logParts, err
+}
+
+func (s *Server) parseLine(line []byte, client string, received time.Time) (LogParts, error) {
+	if s.format == FormatJSON {
+		return ParseJSONLine(line, received)
+	}
+	return s.parseSyslog(line, client)
+}
+
+// Add the connection details and pass the message to the handler
+func (s *Server) handle(logParts LogParts, received time.Time, client string, listener string, tlsPeer string, length int, err error) {
 	if err != nil {
 		s.lastError = err
 	}
 
-	logParts := parser.Dump()
+	addDetails(logParts, received, client, listener, tlsPeer)
+	s.handler.Handle(logParts, int64(length), err)
+}
+
+// Add the connection details. Without hostname, the client is the host
+func addDetails(logParts LogParts, received time.Time, client string, listener string, tlsPeer string) {
+	logParts["received"] = received
 	logParts["client"] = client
+	logParts["listener"] = listener
+	logParts["hostname_from_client"] = logParts["hostname"] == ""
 	if logParts["hostname"] == "" {
-		if i := strings.Index(client, ":"); i > 1 {
-			logParts["hostname"] = client[:i]
-		} else {
-			logParts["hostname"] = client
-		}
+		logParts["hostname"] = ClientHost(client)
 	}
 	logParts["tls_peer"] = tlsPeer
+}
+
+func (s *Server) parseSyslog(line []byte, client string) (LogParts, error) {
+	parser := NewParser(line)
+	if s.locationFunc != nil {
+		if location := s.locationFunc(client); location != nil {
+			parser.Location(location)
+		}
+	}
+	if s.unixTimeUnit > 0 {
+		parser.UnixTimeUnit(s.unixTimeUnit)
+	}
+	err := parser.Parse()
+
+	logParts := parser.Dump()
+	if content, ok := logParts["content"].(string); ok {
+		for key, value := range ParseEventFormat(content) {
+			logParts[key] = value
+		}
+	}
 
-	s.handler.Handle(logParts, int64(len(line)), err)
+	return logParts, err
+}
+
+// ClientHost returns the address of a client without port. IPv6 addresses
+// are returned without brackets
+func ClientHost(client string) string {
+	host, _, err := net.SplitHostPort(client)
+	if err != nil {
+		// e.g. unix sockets
+		return client
+	}
+	return host
 }
 
 //Returns the last error
@@ -297,6 +472,24 @@ func (s *Server) Kill() error {
 			return err
 		}
 	}
+
+	for _, connection := range s.gelfConnections {
+		err := connection.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, listener := range s.gelfListeners {
+		err := listener.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if s.multilineAssembler != nil {
+		s.flushAllMultiline()
+		close(s.multilineAssembler.done)
+	}
 	// Only need to close channel once to broadcast to all waiting
 	if s.doneTcp != nil {
 		close(s.doneTcp)
@@ -323,14 +516,19 @@ type ScanCloser struct {
 }
 
 type DatagramMessage struct {
-	message []byte
-	client  string
+	message  []byte
+	client   string
+	listener string
 }
 
 func (s *Server) goReceiveDatagrams(packetconn net.PacketConn) {
 	s.wait.Add(1)
 	go func() {
 		defer s.wait.Done()
+		var listener string
+		if localAddr := packetconn.LocalAddr(); localAddr != nil {
+			listener = localAddr.String()
+		}
 		for {
 			buf := s.datagramPool.Get().([]byte)
 			n, addr, err := packetconn.ReadFrom(buf)
@@ -343,7 +541,7 @@ func (s *Server) goReceiveDatagrams(packetconn net.PacketConn) {
 					if addr != nil {
 						address = addr.String()
 					}
-					s.datagramChannel <- DatagramMessage{buf[:n], address}
+					s.datagramChannel <- DatagramMessage{buf[:n], address, listener}
 				}
 			} else {
 				// there has been an error. Either the server has been killed
@@ -371,7 +569,7 @@ func (s *Server) goParseDatagrams() {
 				if !ok {
 					return
 				}
-				s.parser(msg.message, msg.client, "")
+				s.parser(msg.message, msg.client, msg.listener, "")
 				s.datagramPool.Put(msg.message[:cap(msg.message)])
 			}
 		}
@@ -27,6 +27,7 @@ package syslog
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -58,6 +59,8 @@ var (
 	ErrVersionNotFound = &ParserError{"Can not find version"}
 
 	ErrTimestampUnknownFormat = &ParserError{"Timestamp format unknown"}
+
+	ErrTagNotFound = &ParserError{"No tag found"}
 )
 
 type LogParser interface {
@@ -215,7 +218,7 @@ func ParseHostname(buff []byte, cursor *int, l int) (string, error) {
 	var to int
 
 	// Handle the envision Header time
-	if string(buff[*cursor:*cursor+4]) == "[][]" {
+	if bytes.HasPrefix(buff[*cursor:l], []byte("[][]")) {
 		from = *cursor + 5
 		for to = from; to < l; to++ {
 			if buff[to] == ']' {
@@ -230,10 +233,64 @@ func ParseHostname(buff []byte, cursor *int, l int) (string, error) {
 		return string(hostname), nil
 	}
 
-	// Hostname shall be parsed by regex
-	*cursor = 0
+	// https://tools.ietf.org/html/rfc3164#section-4.1.2
+	// The hostname is the first word after the timestamp. Messages from
+	// devices not sending a hostname have the tag there instead
+	for to = from; to < l; to++ {
+		if buff[to] == ' ' {
+			break
+		}
+	}
+	hostname := string(buff[from:to])
+	if to < l {
+		to++
+	}
+
+	// RFC5424 NILVALUE
+	if hostname == "-" {
+		*cursor = to
+		return "", nil
+	}
+
+	if !IsHostname(hostname) {
+		return "", nil
+	}
+
+	*cursor = to
+	return strings.Trim(hostname, "[]"), nil
+}
+
+// IsHostname reports whether s is an IP address, including bracketed IPv6
+// literals, or a valid host name or FQDN
+func IsHostname(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+		return net.ParseIP(s) != nil
+	}
+	if net.ParseIP(s) != nil {
+		return true
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !IsDigit(c) && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && c != '-' && c != '_' {
+				return false
+			}
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+	}
 
-	return "", nil
+	return true
 }
 
 func ShowCursorPos(buff []byte, cursor int) {
@@ -242,6 +299,79 @@ func ShowCursorPos(buff []byte, cursor int) {
 	fmt.Println(padding + "↑\n")
 }
 
+// ParseEventFormat detects CEF, LEEF and JSON events in the content and returns
+// their fields. nil is returned for other content
+func ParseEventFormat(content string) LogParts {
+	if strings.Contains(content, cefPrefix) {
+		if logParts, err := ParseCEF(content); err == nil {
+			return logParts
+		}
+	}
+
+	if strings.Contains(content, leefPrefix) {
+		if logParts, err := ParseLEEF(content); err == nil {
+			return logParts
+		}
+	}
+
+	if logParts, err := ParseJSON(content); err == nil {
+		return logParts
+	}
+
+	return nil
+}
+
+// Timestamps without year are dated at most this far in the future
+const inferYearMaxAhead = 31 * 24 * time.Hour
+
+// InferYear sets the year of a timestamp without year, e.g. from RFC3164. The
+// current year is taken, unless that puts the timestamp more than a month in
+// the future, which dates a Dec 31 message received on Jan 1 in the previous
+// year. A Jan 1 message received on Dec 31 is dated in the next year
+func InferYear(ts time.Time) time.Time {
+	if ts.Year() != 0 {
+		return ts
+	}
+
+	now := time.Now()
+	inYear := func(y int) time.Time {
+		return time.Date(y, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(),
+			ts.Second(), ts.Nanosecond(), ts.Location())
+	}
+
+	t := inYear(now.Year())
+	if t.Sub(now) > inferYearMaxAhead {
+		return inYear(now.Year() - 1)
+	}
+	if next := inYear(now.Year() + 1); next.Sub(now) <= inferYearMaxAhead {
+		return next
+	}
+	return t
+}
+
+// UnixTime returns the time of a Unix timestamp in unit. Values too large
+// for the unit are taken by their magnitude, so a timestamp in milliseconds
+// is read correctly with a unit of seconds: from 1e11 as milliseconds, from
+// 1e14 as microseconds and from 1e17 as nanoseconds
+func UnixTime(value int64, unit time.Duration) time.Time {
+	magnitude := time.Second
+	switch {
+	case value >= 1e17:
+		magnitude = time.Nanosecond
+	case value >= 1e14:
+		magnitude = time.Microsecond
+	case value >= 1e11:
+		magnitude = time.Millisecond
+	}
+	if unit <= 0 || unit > magnitude {
+		unit = magnitude
+	}
+
+	// Split into seconds and nanoseconds, which does not overflow
+	perSecond := int64(time.Second / unit)
+	return time.Unix(value/perSecond, (value%perSecond)*int64(unit))
+}
+
 func (err *ParserError) Error() string {
 	return err.ErrorString
 }